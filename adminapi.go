@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// habpanelConfigPath is where HABPanel reads its dashboards from; it is
+// part of the services API, but required by every HABPanel user
+const habpanelConfigPath = "/rest/services/org.openhab.habpanel/config"
+
+// adminEndpoint is a part of the REST API that reconfigures openHAB
+type adminEndpoint struct {
+	prefix string
+	// methods limits the endpoint to writes, if reading is harmless or filtered elsewhere
+	methods []string
+}
+
+var writeMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// adminEndpointsV2 are the administrative endpoints of openHAB 2.5
+var adminEndpointsV2 = []adminEndpoint{
+	{prefix: "/rest/bindings"},
+	{prefix: "/rest/extensions"},
+	{prefix: "/rest/rules"},
+	{prefix: "/rest/module-types"},
+	{prefix: "/rest/templates"},
+	{prefix: "/rest/things", methods: writeMethods},
+	{prefix: "/rest/thing-types"},
+	{prefix: "/rest/channel-types"},
+	{prefix: "/rest/profile-types"},
+	{prefix: "/rest/inbox"},
+	{prefix: "/rest/discovery"},
+	{prefix: "/rest/services"},
+	{prefix: "/rest/config-descriptions"},
+	{prefix: "/rest/links", methods: writeMethods},
+	{prefix: "/rest/persistence", methods: writeMethods},
+}
+
+// adminEndpointsV3 are the administrative endpoints of openHAB 3 and 4,
+// where extensions became add-ons and authentication moved into openHAB
+var adminEndpointsV3 = []adminEndpoint{
+	{prefix: "/rest/addons"},
+	{prefix: "/rest/auth"},
+	{prefix: "/rest/bindings"},
+	{prefix: "/rest/rules"},
+	{prefix: "/rest/module-types"},
+	{prefix: "/rest/templates"},
+	{prefix: "/rest/actions"},
+	{prefix: "/rest/things", methods: writeMethods},
+	{prefix: "/rest/thing-types"},
+	{prefix: "/rest/channel-types"},
+	{prefix: "/rest/profile-types"},
+	{prefix: "/rest/inbox"},
+	{prefix: "/rest/discovery"},
+	{prefix: "/rest/services"},
+	{prefix: "/rest/config-descriptions"},
+	{prefix: "/rest/links", methods: writeMethods},
+	{prefix: "/rest/persistence", methods: writeMethods},
+	{prefix: "/rest/transformations"},
+	{prefix: "/rest/logging"},
+	{prefix: "/rest/tags", methods: writeMethods},
+	{prefix: "/rest/ui", methods: writeMethods},
+}
+
+// adminEndpoints returns the catalogue of the configured openHAB version;
+// without a version, the endpoints of all versions are protected
+func adminEndpoints(version string) []adminEndpoint {
+	switch version {
+	case "2.5":
+		return adminEndpointsV2
+	case "3", "4":
+		return adminEndpointsV3
+	default:
+		return append(append([]adminEndpoint{}, adminEndpointsV2...), adminEndpointsV3...)
+	}
+}
+
+// itemDefinitionResources are the parts of an item, which define it instead of its state
+var itemDefinitionResources = []string{"metadata", "tags", "members"}
+
+// isItemDefinitionChange checks whether the request creates, changes or deletes items;
+// commands via `POST /rest/items/<name>` and `PUT /rest/items/<name>/state` are no such change
+func isItemDefinitionChange(req *http.Request) bool {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "rest" || parts[1] != "items" {
+		return false
+	}
+	switch len(parts) {
+	case 2, 3:
+		return req.Method == http.MethodPut || req.Method == http.MethodPatch || req.Method == http.MethodDelete
+	default:
+		return contains(itemDefinitionResources, parts[3]) && req.Method != http.MethodGet && req.Method != http.MethodHead
+	}
+}
+
+// isAdminRequest checks whether the request targets an administrative endpoint
+func isAdminRequest(req *http.Request, version string) bool {
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	if path == habpanelConfigPath && (req.Method == http.MethodGet || req.Method == http.MethodHead) {
+		return false
+	}
+	if isItemDefinitionChange(req) {
+		return true
+	}
+
+	for _, endpoint := range adminEndpoints(version) {
+		if path != endpoint.prefix && !strings.HasPrefix(path, endpoint.prefix+"/") {
+			continue
+		}
+		if len(endpoint.methods) == 0 {
+			return true
+		}
+		for _, method := range endpoint.methods {
+			if req.Method == method {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_isAdminRequest(t *testing.T) {
+	tests := []struct {
+		method   string
+		uri      string
+		version  string
+		expected bool
+	}{
+		{method: "GET", uri: "/rest/bindings", expected: true},
+		{method: "PUT", uri: "/rest/bindings/hue/config", expected: true},
+		{method: "GET", uri: "/rest/extensions", version: "2.5", expected: true},
+		{method: "GET", uri: "/rest/extensions", version: "3", expected: false},
+		{method: "GET", uri: "/rest/addons", version: "2.5", expected: false},
+		{method: "GET", uri: "/rest/addons", expected: true},
+		{method: "PUT", uri: "/rest/logging/org.openhab", expected: true},
+		{method: "GET", uri: "/rest/extensions", expected: true},
+		{method: "GET", uri: "/rest/addons", version: "4", expected: true},
+		{method: "POST", uri: "/rest/auth/token", version: "3", expected: true},
+		{method: "GET", uri: "/rest/things", expected: false},
+		{method: "DELETE", uri: "/rest/things/hue:0210:1", expected: true},
+		{method: "GET", uri: "/rest/services/org.openhab.habpanel/config", expected: false},
+		{method: "PUT", uri: "/rest/services/org.openhab.habpanel/config", expected: true},
+		{method: "GET", uri: "/rest/services/org.openhab.i18n/config", expected: true},
+		{method: "GET", uri: "/rest/rulesets", expected: false},
+		{method: "POST", uri: "/rest/items/Light_Kids", expected: false},
+		{method: "PUT", uri: "/rest/items/Light_Kids/state", expected: false},
+		{method: "GET", uri: "/rest/items/Light_Kids", expected: false},
+		{method: "PUT", uri: "/rest/items/Light_Kids", expected: true},
+		{method: "DELETE", uri: "/rest/items/Light_Kids", expected: true},
+		{method: "PUT", uri: "/rest/items", expected: true},
+		{method: "PUT", uri: "/rest/items/Light_Kids/metadata/alexa", expected: true},
+		{method: "DELETE", uri: "/rest/items/Light_Kids/tags/Lighting", expected: true},
+		{method: "PUT", uri: "/rest/items/Lights/members/Light_Kids", expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.uri+" "+tt.version, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.uri, nil)
+			assert.Equal(t, tt.expected, isAdminRequest(req, tt.version))
+		})
+	}
+}
+
+func TestAdminAPIIsDeniedToUsers(t *testing.T) {
+	_, mux, closeRemote := testRouter(t, &config.Main{
+		Users: map[string]*config.User{
+			"admin": {Entrypoint: "/start/index", Sitemaps: config.Sitemap{Default: "admin", Allowed: []string{"*"}}, AdminAPI: true},
+			"guest": {Entrypoint: "/basicui/app", Sitemaps: config.Sitemap{Default: "demo", Allowed: []string{"demo"}}},
+		},
+	}, func(w http.ResponseWriter, r *http.Request) {})
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/bindings", "guest"))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/bindings", "admin"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// maxAdminBodySize limits the documents sent to the admin server
+const maxAdminBodySize = 1024 * 1024
+
+// AdminServer manages the users and routes of the config file at runtime.
+//
+// Changes are validated like the config at startup, written back to the
+// file and put into effect without a restart.
+type AdminServer struct {
+	router *Router
+	path   string
+	token  string
+
+	// mu serializes changes, which read, modify and write the config file
+	mu sync.Mutex
+}
+
+// NewAdminServer reads the bearer token clients of the admin server have to send
+func NewAdminServer(router *Router, path string, tokenFile string) (*AdminServer, error) {
+	data, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin token: %s", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return nil, fmt.Errorf("the admin token file '%s' is empty", tokenFile)
+	}
+
+	// changes are written back as YAML
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".yaml" && ext != ".yml" {
+		return nil, fmt.Errorf("the admin api requires a YAML config file, but '%s' is not", path)
+	}
+
+	// a user could be defined in any of the files of a split config
+	admin := &AdminServer{router: router, path: path, token: token}
+	_, root, err := admin.readDocument()
+	if err != nil {
+		return nil, fmt.Errorf("the admin api requires a single config file: %s", err)
+	}
+	if mappingValue(root, "include") != nil {
+		return nil, fmt.Errorf("the admin api requires a single config file, but '%s' includes others", path)
+	}
+	return admin, nil
+}
+
+func (a *AdminServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// the page itself holds no data, it asks for the token
+	if req.URL.Path == "/" || req.URL.Path == "/ui" || req.URL.Path == "/ui/" {
+		serveUI(w, req)
+		return
+	}
+	if !a.authorized(req) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="openhab-auth-router"`)
+		_ = writeProblem(w, req, http.StatusUnauthorized, "A valid bearer token is required.")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "users":
+		if methodAllowed(w, req, http.MethodGet) {
+			a.serveSection(w, req, "users")
+		}
+	case len(parts) == 2 && parts[0] == "users" && parts[1] != "":
+		a.serveUser(w, req, parts[1])
+	case len(parts) == 1 && parts[0] == "routes":
+		a.serveRoutes(w, req)
+	case len(parts) == 1 && parts[0] == "sitemaps":
+		a.serveSitemaps(w, req)
+	case len(parts) == 1 && parts[0] == "simulate":
+		a.serveSimulation(w, req)
+	default:
+		_ = writeProblem(w, req, http.StatusNotFound, "Unknown resource.")
+	}
+}
+
+func (a *AdminServer) authorized(req *http.Request) bool {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == req.Header.Get("Authorization") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// serveSection responds with a top level section of the config file
+func (a *AdminServer) serveSection(w http.ResponseWriter, req *http.Request, key string) {
+	_, root, err := a.readDocument()
+	if err != nil {
+		a.fail(w, req, err)
+		return
+	}
+	writeResource(w, http.StatusOK, nodeValue(mappingValue(root, key)))
+}
+
+// serveRoutes replaces the routes as a whole, as they are matched by prefix
+func (a *AdminServer) serveRoutes(w http.ResponseWriter, req *http.Request) {
+	if !methodAllowed(w, req, http.MethodGet, http.MethodPut) {
+		return
+	}
+	if req.Method == http.MethodGet {
+		a.serveSection(w, req, "routes")
+		return
+	}
+
+	value, ok := readResource(w, req, &map[string]string{})
+	if !ok {
+		return
+	}
+	a.update(w, req, "routes", func(root *yaml3.Node) (interface{}, int, error) {
+		current := nodeValue(mappingValue(root, "routes"))
+		if status, err := preconditionFailed(req, current, true); err != nil {
+			return nil, status, err
+		}
+		setMappingValue(root, "routes", value)
+		return nodeValue(value), http.StatusOK, nil
+	})
+}
+
+func (a *AdminServer) serveUser(w http.ResponseWriter, req *http.Request, name string) {
+	if !methodAllowed(w, req, http.MethodGet, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	if req.Method == http.MethodGet {
+		_, root, err := a.readDocument()
+		if err != nil {
+			a.fail(w, req, err)
+			return
+		}
+		user := mappingValue(mappingValue(root, "users"), name)
+		if user == nil {
+			_ = writeProblem(w, req, http.StatusNotFound, fmt.Sprintf("The user '%s' does not exist.", name))
+			return
+		}
+		writeResource(w, http.StatusOK, nodeValue(user))
+		return
+	}
+
+	var value *yaml3.Node
+	if req.Method == http.MethodPut {
+		var ok bool
+		if value, ok = readResource(w, req, &config.User{}); !ok {
+			return
+		}
+		if len(value.Content) == 0 {
+			_ = writeProblem(w, req, http.StatusBadRequest, "The user must not be empty.")
+			return
+		}
+	}
+	a.update(w, req, "users."+name, func(root *yaml3.Node) (interface{}, int, error) {
+		users := mappingValue(root, "users")
+		current := mappingValue(users, name)
+		exists := current != nil
+		if !exists && req.Method == http.MethodDelete {
+			return nil, http.StatusNotFound, fmt.Errorf("The user '%s' does not exist.", name)
+		}
+		if status, err := preconditionFailed(req, nodeValue(current), exists); err != nil {
+			return nil, status, err
+		}
+
+		status := http.StatusOK
+		if !exists {
+			status = http.StatusCreated
+		}
+		if req.Method == http.MethodDelete {
+			removeMappingValue(users, name)
+			return nil, http.StatusNoContent, nil
+		}
+		if users == nil || users.Kind != yaml3.MappingNode {
+			users = &yaml3.Node{Kind: yaml3.MappingNode, Tag: "!!map"}
+			setMappingValue(root, "users", users)
+		}
+		setMappingValue(users, name, value)
+		return nodeValue(value), status, nil
+	})
+}
+
+// update applies the change to the config file and puts the result into effect, if it is valid
+func (a *AdminServer) update(w http.ResponseWriter, req *http.Request, resource string,
+	change func(*yaml3.Node) (interface{}, int, error)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	doc, root, err := a.readDocument()
+	if err != nil {
+		a.fail(w, req, err)
+		return
+	}
+	value, status, err := change(root)
+	if err != nil {
+		_ = writeProblem(w, req, status, err.Error())
+		return
+	}
+
+	data, err := marshalDocument(doc)
+	if err != nil {
+		a.fail(w, req, err)
+		return
+	}
+	updated, err := parseConfig(data)
+	if err != nil {
+		_ = writeProblem(w, req, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err := config.Validate(updated); err != nil {
+		_ = writeProblem(w, req, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err := config.ResolveCredentials(updated); err != nil {
+		_ = writeProblem(w, req, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err := a.checkUpstreams(updated); err != nil {
+		_ = writeProblem(w, req, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	if err := writeConfigFile(a.path, data); err != nil {
+		a.fail(w, req, err)
+		return
+	}
+	conf := *a.router.CurrentConfig()
+	conf.Users = updated.Users
+	conf.Routes = updated.Routes
+	a.router.SwapConfig(&conf)
+	log.Info().Str("resource", resource).Str("method", req.Method).Msg("config changed by admin api")
+
+	if status == http.StatusCreated {
+		w.Header().Set("Location", req.URL.Path)
+	}
+	writeResource(w, status, value)
+}
+
+// checkUpstreams ensures the routes and users only refer to the upstreams the router
+// created on startup; upstreams added to the file only take effect after a restart
+func (a *AdminServer) checkUpstreams(conf *config.Main) error {
+	for path, upstream := range conf.Routes {
+		if _, ok := a.router.Upstreams[upstream]; !ok {
+			return fmt.Errorf("The route '%s' refers to the upstream '%s', which requires a restart of the router", path, upstream)
+		}
+	}
+	for name, user := range conf.Users {
+		if _, ok := a.router.Upstreams[user.Upstream]; user.Upstream != "" && !ok {
+			return fmt.Errorf("The user '%s' refers to the upstream '%s', which requires a restart of the router", name, user.Upstream)
+		}
+	}
+	return nil
+}
+
+func (a *AdminServer) fail(w http.ResponseWriter, req *http.Request, err error) {
+	log.Error().Err(err).Str("uri", req.URL.RequestURI()).Msg("admin api request failed")
+	_ = writeProblem(w, req, http.StatusInternalServerError, "Failed to access the config file.")
+}
+
+// readDocument returns the config file and its top level mapping; the nodes keep
+// the order of the keys and the comments when the file is written back
+func (a *AdminServer) readDocument() (*yaml3.Node, *yaml3.Node, error) {
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc := &yaml3.Node{}
+	if err := yaml3.Unmarshal(data, doc); err != nil {
+		return nil, nil, err
+	}
+	if len(doc.Content) == 0 {
+		doc = &yaml3.Node{Kind: yaml3.DocumentNode, Content: []*yaml3.Node{{Kind: yaml3.MappingNode, Tag: "!!map"}}}
+	}
+	if doc.Content[0].Kind != yaml3.MappingNode {
+		return nil, nil, fmt.Errorf("the config file '%s' is not a mapping", a.path)
+	}
+	return doc, doc.Content[0], nil
+}
+
+// marshalDocument writes the document with the indentation of the examples
+func marshalDocument(doc *yaml3.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml3.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeConfigFile replaces the file atomically and keeps the previous version as `<file>.bak`
+func writeConfigFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	previous, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".bak", previous, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readResource decodes the request body into the type of target to reject unknown fields;
+// the returned node keeps the document as sent to write it to the config file
+func readResource(w http.ResponseWriter, req *http.Request, target interface{}) (*yaml3.Node, bool) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxAdminBodySize))
+	if err != nil {
+		_ = writeProblem(w, req, http.StatusRequestEntityTooLarge, "The request body is too large.")
+		return nil, false
+	}
+	// placeholders are resolved by the router, so they could disclose environment variables and files
+	if strings.Contains(string(data), "${") {
+		_ = writeProblem(w, req, http.StatusBadRequest, "Placeholders like '${NAME}' can only be added by editing the config file.")
+		return nil, false
+	}
+	// JSON is valid YAML
+	if err := yaml.UnmarshalStrict(data, target); err != nil {
+		_ = writeProblem(w, req, http.StatusBadRequest, fmt.Sprintf("The request body is invalid: %s", err))
+		return nil, false
+	}
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal(data, &doc); err != nil {
+		_ = writeProblem(w, req, http.StatusBadRequest, fmt.Sprintf("The request body is invalid: %s", err))
+		return nil, false
+	}
+	value := &yaml3.Node{Kind: yaml3.MappingNode, Tag: "!!map"}
+	if len(doc.Content) > 0 && doc.Content[0].Kind == yaml3.MappingNode {
+		value = doc.Content[0]
+	}
+	// JSON bodies are written in the block style of the config file
+	blockStyle(value)
+	return value, true
+}
+
+func writeResource(w http.ResponseWriter, status int, value interface{}) {
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(value))
+	w.WriteHeader(status)
+	body := jsonValue(value)
+	if value == nil {
+		body = map[string]interface{}{}
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Error().Err(err).Msg("failed to write admin api response")
+	}
+}
+
+func methodAllowed(w http.ResponseWriter, req *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if req.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	_ = writeProblem(w, req, http.StatusMethodNotAllowed, fmt.Sprintf("The method %s is not allowed.", req.Method))
+	return false
+}
+
+// preconditionFailed checks the conditional headers, so clients do not overwrite changes they have not seen;
+// changing an existing resource requires `If-Match`
+func preconditionFailed(req *http.Request, current interface{}, exists bool) (int, error) {
+	ifMatch := req.Header.Get("If-Match")
+	if req.Header.Get("If-None-Match") == "*" && exists {
+		return http.StatusPreconditionFailed, fmt.Errorf("The resource already exists.")
+	}
+	if ifMatch == "" {
+		if exists {
+			return http.StatusPreconditionRequired, fmt.Errorf("The header If-Match is required to change the resource.")
+		}
+		return 0, nil
+	}
+	if !exists || !etagMatches(ifMatch, etag(current)) {
+		return http.StatusPreconditionFailed, fmt.Errorf("The resource has been changed in the meantime.")
+	}
+	return 0, nil
+}
+
+func etagMatches(header string, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// etag identifies a version of the resource by its YAML representation
+func etag(value interface{}) string {
+	data, _ := yaml.Marshal(value)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// lookup finds the value of the key in a mapping of the config file
+func lookup(mapping interface{}, key string) (interface{}, bool) {
+	m, _ := mapping.(yaml.MapSlice)
+	for _, item := range m {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+// mappingValue finds the node of the key in a mapping of the config file
+func mappingValue(mapping *yaml3.Node, key string) *yaml3.Node {
+	if mapping == nil || mapping.Kind != yaml3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue replaces the node of the key in place or appends it; the comments
+// of the key and the replaced node are kept
+func setMappingValue(mapping *yaml3.Node, key string, value *yaml3.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			current := mapping.Content[i+1]
+			value.HeadComment, value.LineComment, value.FootComment = current.HeadComment, current.LineComment, current.FootComment
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func removeMappingValue(mapping *yaml3.Node, key string) {
+	if mapping == nil || mapping.Kind != yaml3.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// blockStyle drops the flow style and quotes of a document, which the encoder adds where needed
+func blockStyle(node *yaml3.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// nodeValue decodes a node of the config file for responses
+func nodeValue(node *yaml3.Node) interface{} {
+	if node == nil {
+		return nil
+	}
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil
+	}
+	return value
+}
+
+func remove(mapping interface{}, key string) yaml.MapSlice {
+	m, _ := mapping.(yaml.MapSlice)
+	result := yaml.MapSlice{}
+	for _, item := range m {
+		if fmt.Sprint(item.Key) != key {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// jsonValue converts the YAML mappings of the config file, which JSON cannot encode
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		result := make(map[string]interface{}, len(v))
+		for _, item := range v {
+			result[fmt.Sprint(item.Key)] = jsonValue(item.Value)
+		}
+		return result
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = jsonValue(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const adminTestConfig = `passthrough: false
+upstreams:
+  garage:
+    target: http://garage:8080
+users:
+  john:
+    entrypoint: /start/index
+    sitemaps:
+      default: john
+      allowed:
+        - john
+`
+
+func makeAdminServer(t *testing.T) (*AdminServer, *Router, string, func()) {
+	dir, err := ioutil.TempDir("", "router")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(adminTestConfig), 0640))
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600))
+
+	conf := &config.Main{}
+	assert.NoError(t, yaml.Unmarshal([]byte(adminTestConfig), conf))
+	router, err := NewRouter(&Options{Target: "http://openhab:8080"}, validConfig(t, conf))
+	assert.NoError(t, err)
+	admin, err := NewAdminServer(router, path, tokenFile)
+	assert.NoError(t, err)
+	return admin, router, path, func() { _ = os.RemoveAll(dir) }
+}
+
+func adminRequest(admin *AdminServer, method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAdminServerRequiresToken(t *testing.T) {
+	admin, _, _, cleanup := makeAdminServer(t)
+	defer cleanup()
+
+	for _, header := range []string{"", "Bearer wrong", "secret"} {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Authorization", header)
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, header)
+		assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "Bearer")
+	}
+}
+
+func TestAdminServerReadsUsers(t *testing.T) {
+	admin, _, _, cleanup := makeAdminServer(t)
+	defer cleanup()
+
+	rr := adminRequest(admin, http.MethodGet, "/users/john", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"entrypoint":"/start/index","sitemaps":{"default":"john","allowed":["john"]}}`, rr.Body.String())
+	assert.NotEmpty(t, rr.Header().Get("ETag"))
+
+	rr = adminRequest(admin, http.MethodGet, "/users", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"john"`)
+
+	rr = adminRequest(admin, http.MethodGet, "/users/jane", "", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Type"), "application/problem+json")
+
+	rr = adminRequest(admin, http.MethodPost, "/users/john", "", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "GET, PUT, DELETE", rr.Header().Get("Allow"))
+}
+
+func TestAdminServerUpdatesUser(t *testing.T) {
+	admin, router, path, cleanup := makeAdminServer(t)
+	defer cleanup()
+	body := `{"entrypoint":"/basicui/app","sitemaps":{"default":"john","allowed":["john","garden"]}}`
+
+	rr := adminRequest(admin, http.MethodPut, "/users/john", body, nil)
+	assert.Equal(t, http.StatusPreconditionRequired, rr.Code)
+
+	rr = adminRequest(admin, http.MethodPut, "/users/john", body, map[string]string{"If-Match": `"outdated"`})
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+	tag := adminRequest(admin, http.MethodGet, "/users/john", "", nil).Header().Get("ETag")
+	rr = adminRequest(admin, http.MethodPut, "/users/john", body, map[string]string{"If-Match": tag})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEqual(t, tag, rr.Header().Get("ETag"))
+
+	assert.Equal(t, "/basicui/app", router.CurrentConfig().Users["john"].Entrypoint)
+	assert.Equal(t, []string{"john", "garden"}, router.CurrentConfig().Users["john"].Sitemaps.Allowed)
+
+	written, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(written), "entrypoint: /basicui/app")
+	// the order of the sections is kept
+	assert.True(t, strings.Index(string(written), "upstreams:") < strings.Index(string(written), "users:"))
+	backup, err := ioutil.ReadFile(path + ".bak")
+	assert.NoError(t, err)
+	assert.Equal(t, adminTestConfig, string(backup))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	// the previous version cannot be overwritten
+	rr = adminRequest(admin, http.MethodPut, "/users/john", body, map[string]string{"If-Match": tag})
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+}
+
+func TestAdminServerCreatesAndDeletesUser(t *testing.T) {
+	admin, router, _, cleanup := makeAdminServer(t)
+	defer cleanup()
+	body := `{"entrypoint":"/start/index","sitemaps":{"default":"jane","allowed":["jane"]},"upstream":"garage"}`
+
+	rr := adminRequest(admin, http.MethodPut, "/users/john", body, map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+	rr = adminRequest(admin, http.MethodPut, "/users/jane", body, map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "/users/jane", rr.Header().Get("Location"))
+	assert.Equal(t, "garage", router.CurrentConfig().Users["jane"].Upstream)
+
+	rr = adminRequest(admin, http.MethodDelete, "/users/jane", "", nil)
+	assert.Equal(t, http.StatusPreconditionRequired, rr.Code)
+
+	rr = adminRequest(admin, http.MethodDelete, "/users/jane", "", map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.NotContains(t, router.CurrentConfig().Users, "jane")
+
+	rr = adminRequest(admin, http.MethodDelete, "/users/jane", "", map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAdminServerRejectsInvalidChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{name: "unknown field", path: "/users/jane", body: `{"entrypoint":"/start/index","sitemap":{}}`, status: http.StatusBadRequest},
+		{name: "empty user", path: "/users/jane", body: `{}`, status: http.StatusBadRequest},
+		{name: "missing entrypoint", path: "/users/jane", body: `{"sitemaps":{"default":"jane","allowed":["jane"]}}`, status: http.StatusUnprocessableEntity},
+		{name: "unknown upstream", path: "/users/jane", body: `{"entrypoint":"/start/index","sitemaps":{"default":"jane","allowed":["jane"]},"upstream":"attic"}`, status: http.StatusUnprocessableEntity},
+		{name: "route to unknown upstream", path: "/routes", body: `{"/rest/":"attic"}`, status: http.StatusUnprocessableEntity},
+		{name: "placeholder", path: "/users/jane", body: `{"entrypoint":"${file:/etc/passwd}","sitemaps":{"default":"jane","allowed":["jane"]}}`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin, router, path, cleanup := makeAdminServer(t)
+			defer cleanup()
+			previous := router.CurrentConfig()
+
+			// new users must not exist yet, the routes always do
+			condition := map[string]string{"If-None-Match": "*"}
+			if tt.path == "/routes" {
+				condition = map[string]string{"If-Match": "*"}
+			}
+			rr := adminRequest(admin, http.MethodPut, tt.path, tt.body, condition)
+			assert.Equal(t, tt.status, rr.Code)
+			assert.Equal(t, previous, router.CurrentConfig())
+			written, err := ioutil.ReadFile(path)
+			assert.NoError(t, err)
+			assert.Equal(t, adminTestConfig, string(written))
+		})
+	}
+}
+
+func TestAdminServerReplacesRoutes(t *testing.T) {
+	admin, router, _, cleanup := makeAdminServer(t)
+	defer cleanup()
+
+	rr := adminRequest(admin, http.MethodGet, "/routes", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{}`, rr.Body.String())
+
+	rr = adminRequest(admin, http.MethodPut, "/routes", `{"/rest/":"garage"}`, map[string]string{"If-Match": rr.Header().Get("ETag")})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, map[string]string{"/rest/": "garage"}, router.CurrentConfig().Routes)
+}
+
+func TestAdminServerRequiresRunningUpstreams(t *testing.T) {
+	admin, router, path, cleanup := makeAdminServer(t)
+	defer cleanup()
+	previous := router.CurrentConfig()
+	assert.NoError(t, ioutil.WriteFile(path, []byte(strings.Replace(adminTestConfig, "upstreams:\n", "upstreams:\n  attic:\n    target: http://attic:8080\n", 1)), 0640))
+
+	rr := adminRequest(admin, http.MethodPut, "/routes", `{"/rest/":"attic"}`, map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "requires a restart of the router")
+
+	rr = adminRequest(admin, http.MethodPut, "/users/jane", `{"entrypoint":"/start/index","sitemaps":{"default":"jane","allowed":["jane"]},"upstream":"attic"}`, map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, previous, router.CurrentConfig())
+}
+
+func TestAdminServerKeepsPlaceholders(t *testing.T) {
+	os.Setenv("OPENHAB_AUTH_ROUTER_TEST_TOKEN", "env-token")
+	defer os.Unsetenv("OPENHAB_AUTH_ROUTER_TEST_TOKEN")
+	admin, router, path, cleanup := makeAdminServer(t)
+	defer cleanup()
+	assert.NoError(t, ioutil.WriteFile(path, []byte(adminTestConfig+`    upstream_auth:
+      token: ${OPENHAB_AUTH_ROUTER_TEST_TOKEN}
+`), 0640))
+
+	rr := adminRequest(admin, http.MethodGet, "/users/john", "", nil)
+	assert.Contains(t, rr.Body.String(), "${OPENHAB_AUTH_ROUTER_TEST_TOKEN}")
+
+	rr = adminRequest(admin, http.MethodPut, "/users/jane", `{"entrypoint":"/start/index","sitemaps":{"default":"jane","allowed":["jane"]}}`, nil)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "env-token", router.CurrentConfig().Users["john"].UpstreamAuth.Token)
+	written, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(written), "${OPENHAB_AUTH_ROUTER_TEST_TOKEN}")
+	assert.NotContains(t, string(written), "env-token")
+}
+
+func TestAdminServerRequiresSingleConfigFile(t *testing.T) {
+	_, router, path, cleanup := makeAdminServer(t)
+	defer cleanup()
+	tokenFile := filepath.Join(filepath.Dir(path), "token")
+
+	_, err := NewAdminServer(router, filepath.Dir(path), tokenFile)
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("include: [users.yaml]\n"+adminTestConfig), 0640))
+	_, err = NewAdminServer(router, path, tokenFile)
+	assert.Error(t, err)
+
+	jsonPath := filepath.Join(filepath.Dir(path), "config.json")
+	assert.NoError(t, ioutil.WriteFile(jsonPath, []byte("{}"), 0640))
+	_, err = NewAdminServer(router, jsonPath, tokenFile)
+	assert.EqualError(t, err, "the admin api requires a YAML config file, but '"+jsonPath+"' is not")
+}
+
+func TestAdminServerKeepsComments(t *testing.T) {
+	admin, _, path, cleanup := makeAdminServer(t)
+	defer cleanup()
+	commented := "# router of the family\n\npassthrough: false # no auth proxy in front\nupstreams:\n  garage:\n    target: http://garage:8080\n" +
+		"users:\n  # the owner\n  john:\n    entrypoint: /start/index\n    sitemaps:\n      default: john\n      allowed:\n        - john\n" +
+		"  guest:\n    entrypoint: /start/index\n    sitemaps:\n      default: guest\n      allowed:\n        - guest\n"
+	assert.NoError(t, ioutil.WriteFile(path, []byte(commented), 0640))
+
+	body := `{"entrypoint":"/basicui/app","sitemaps":{"default":"john","allowed":["john","true"]}}`
+	rr := adminRequest(admin, http.MethodPut, "/users/john", body, map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = adminRequest(admin, http.MethodDelete, "/users/guest", "", map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	written, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "# router of the family\n\npassthrough: false # no auth proxy in front\nupstreams:\n  garage:\n    target: http://garage:8080\n"+
+		"users:\n  # the owner\n  john:\n    entrypoint: /basicui/app\n    sitemaps:\n      default: john\n      allowed:\n        - john\n        - \"true\"\n",
+		string(written))
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+)
+
+// Simulation is a request the admin UI asks the router to decide on
+type Simulation struct {
+	User     string `json:"user"`
+	Method   string `json:"method"`
+	URL      string `json:"url"`
+	ClientIP string `json:"client_ip"`
+	Accept   string `json:"accept"`
+	Body     string `json:"body"`
+}
+
+// SimulationResult tells what the router would do with the request
+type SimulationResult struct {
+	Decision string `json:"decision"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Location string `json:"location,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+	Backend  string `json:"backend,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+// simulationTransport answers in place of openHAB, so simulated requests never leave the router
+type simulationTransport struct {
+	forwarded *http.Request
+}
+
+func (t *simulationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.forwarded = req
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+// Simulate runs the request through the same checks and routing decisions as real requests;
+// rate limits are left out, as they would take tokens from the user.
+//
+// Without `accept`, the request is sent like a browser opening the URL.
+func (r *Router) Simulate(s *Simulation) (*SimulationResult, error) {
+	conf := r.CurrentConfig()
+	if s.Method == "" {
+		s.Method = http.MethodGet
+	}
+	if s.Accept == "" {
+		s.Accept = "text/html"
+	}
+	target, err := url.ParseRequestURI(s.URL)
+	if err != nil || !strings.HasPrefix(s.URL, "/") {
+		return nil, fmt.Errorf("the url has to be a path like '/basicui/app?sitemap=home'")
+	}
+	clientIP := s.ClientIP
+	if clientIP == "" {
+		clientIP = "127.0.0.1"
+	}
+	if net.ParseIP(clientIP) == nil {
+		return nil, fmt.Errorf("the client ip '%s' is invalid", clientIP)
+	}
+
+	req := httptest.NewRequest(s.Method, target.RequestURI(), strings.NewReader(s.Body))
+	req.RemoteAddr = net.JoinHostPort(clientIP, "0")
+	req.Header.Set("Accept", s.Accept)
+	if s.User != "" {
+		req.Header.Set("X-Forwarded-Username", s.User)
+	}
+	stripBasePath(req, conf.BasePath)
+	requested := req.URL.RequestURI()
+
+	result := &SimulationResult{}
+	transport := &simulationTransport{}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			result.Upstream = r.routeRequest(req, conf)
+		},
+		Transport: transport,
+	}
+	rr := httptest.NewRecorder()
+	mainHandler(rr, req, r.ErrorPages, conf, proxy)
+
+	result.Status = rr.Code
+	switch {
+	case transport.forwarded != nil:
+		result.Decision = "allow"
+		result.URL = requestURL(transport.forwarded).RequestURI()
+		result.Backend = transport.forwarded.URL.String()
+		if result.URL != requested {
+			result.Decision = "rewrite"
+		}
+	case rr.Code >= 300 && rr.Code < 400:
+		result.Decision = "redirect"
+		result.Location = rr.Header().Get("Location")
+	case rr.Code == http.StatusForbidden:
+		result.Decision = "deny"
+	default:
+		result.Decision = "error"
+	}
+	if transport.forwarded == nil && rr.Code >= 400 {
+		result.Detail = errorDetail(rr)
+	}
+	return result, nil
+}
+
+// errorDetail extracts the reason from problem documents and text responses; error pages are left out
+func errorDetail(rr *httptest.ResponseRecorder) string {
+	contentType := rr.Header().Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "application/problem+json"):
+		var p problem
+		if json.Unmarshal(rr.Body.Bytes(), &p) == nil {
+			return p.Detail
+		}
+	case strings.HasPrefix(contentType, "text/plain"):
+		return rr.Body.String()
+	}
+	return ""
+}
+
+// serveSimulation decides on a request like the router would, without sending it to openHAB
+func (a *AdminServer) serveSimulation(w http.ResponseWriter, req *http.Request) {
+	if !methodAllowed(w, req, http.MethodPost) {
+		return
+	}
+	var simulation Simulation
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxAdminBodySize)).Decode(&simulation); err != nil {
+		_ = writeProblem(w, req, http.StatusBadRequest, fmt.Sprintf("The request body is invalid: %s", err))
+		return
+	}
+	result, err := a.router.Simulate(&simulation)
+	if err != nil {
+		_ = writeProblem(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// serveSitemaps lists the sitemaps of openHAB, as seen with the upstream and credentials of the given user
+func (a *AdminServer) serveSitemaps(w http.ResponseWriter, req *http.Request) {
+	if !methodAllowed(w, req, http.MethodGet) {
+		return
+	}
+	user := &config.User{}
+	listReq, err := http.NewRequest(http.MethodGet, "/rest/sitemaps", nil)
+	if err != nil {
+		a.fail(w, req, err)
+		return
+	}
+	if name := req.URL.Query().Get("user"); name != "" {
+		if known, ok := a.router.CurrentConfig().Users[name]; ok {
+			user = known
+			listReq.Header.Set("X-Forwarded-Username", name)
+		}
+	}
+
+	sitemaps, err := a.router.fetchSitemaps(listReq.WithContext(req.Context()), user)
+	if err != nil {
+		_ = writeProblem(w, req, http.StatusBadGateway, fmt.Sprintf("Failed to list the sitemaps of openHAB: %s", err))
+		return
+	}
+	type sitemap struct {
+		Name  string `json:"name"`
+		Label string `json:"label"`
+	}
+	result := make([]sitemap, 0, len(sitemaps))
+	for _, s := range sitemaps {
+		result = append(result, sitemap{Name: s.Name, Label: s.Label})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// serveUI responds with the admin UI; it asks for the token and sends it along with its API requests
+func serveUI(w http.ResponseWriter, req *http.Request) {
+	if !methodAllowed(w, req, http.MethodGet, http.MethodHead) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = w.Write([]byte(adminUIPage))
+}
+
+// adminUIPage edits the users through the admin API; it only uses `textContent`
+// to render values, so names and paths from the config cannot inject markup
+const adminUIPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>openhab-auth-router admin</title>
+  <style>
+    body { font-family: sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; color: #333; }
+    h1, h2 { font-weight: normal; }
+    section { border-top: 1px solid #ddd; padding: 1em 0; }
+    label { display: block; margin: .5em 0 .2em; }
+    input[type=text], select, textarea { width: 100%; box-sizing: border-box; }
+    table { width: 100%; border-collapse: collapse; }
+    td { padding: .2em; }
+    #users li { cursor: pointer; }
+    #users li.selected { font-weight: bold; }
+    .error { color: #c62828; }
+    .hidden { display: none; }
+  </style>
+</head>
+<body>
+  <h1>openhab-auth-router</h1>
+  <p id="message"></p>
+
+  <section id="login">
+    <label for="token">Admin token</label>
+    <input type="password" id="token">
+    <button id="signin">Sign in</button>
+  </section>
+
+  <div id="app" class="hidden">
+    <section>
+      <h2>Users</h2>
+      <ul id="users"></ul>
+      <input type="text" id="new-name" placeholder="name of a new user">
+      <button id="create">Create</button>
+    </section>
+
+    <section id="editor" class="hidden">
+      <h2 id="editor-title"></h2>
+      <label for="entrypoint">Entrypoint</label>
+      <input type="text" id="entrypoint">
+      <label for="default-sitemap">Default sitemap</label>
+      <select id="default-sitemap"></select>
+      <label for="allowed-sitemaps">Allowed sitemaps</label>
+      <select id="allowed-sitemaps" multiple size="6"></select>
+      <label>Path rules</label>
+      <table>
+        <tbody id="paths"></tbody>
+      </table>
+      <button id="add-path">Add path</button>
+      <p>
+        <button id="save">Save</button>
+        <button id="delete">Delete</button>
+      </p>
+    </section>
+
+    <section>
+      <h2>Simulation</h2>
+      <label for="sim-user">User</label>
+      <input type="text" id="sim-user">
+      <label for="sim-method">Method</label>
+      <select id="sim-method"><option>GET</option><option>POST</option><option>PUT</option><option>DELETE</option></select>
+      <label for="sim-url">URL</label>
+      <input type="text" id="sim-url" placeholder="/basicui/app?sitemap=home">
+      <label for="sim-accept">Client</label>
+      <select id="sim-accept"><option value="text/html">Browser</option><option value="application/json">REST API</option></select>
+      <label for="sim-ip">Client IP</label>
+      <input type="text" id="sim-ip" placeholder="127.0.0.1">
+      <label for="sim-body">Body</label>
+      <textarea id="sim-body" rows="2"></textarea>
+      <p><button id="simulate">Simulate</button></p>
+      <pre id="sim-result"></pre>
+    </section>
+  </div>
+
+<script>
+(function () {
+  var state = { token: sessionStorage.getItem("token") || "", user: null, etag: null, data: null };
+  var $ = function (id) { return document.getElementById(id); };
+
+  function show(text, error) {
+    $("message").textContent = text;
+    $("message").className = error ? "error" : "";
+  }
+
+  function api(method, path, body, headers) {
+    headers = headers || {};
+    headers["Authorization"] = "Bearer " + state.token;
+    if (body !== undefined) {
+      headers["Content-Type"] = "application/json";
+      body = JSON.stringify(body);
+    }
+    return fetch(path, { method: method, headers: headers, body: body }).then(function (resp) {
+      if (resp.status === 401) {
+        sessionStorage.removeItem("token");
+        $("login").classList.remove("hidden");
+        $("app").classList.add("hidden");
+      }
+      if (resp.status === 204) {
+        return { resp: resp, body: null };
+      }
+      return resp.json().then(function (json) {
+        if (!resp.ok) {
+          throw new Error(json.detail || json.title || resp.statusText);
+        }
+        return { resp: resp, body: json };
+      });
+    });
+  }
+
+  function option(select, value, label, selected) {
+    var o = document.createElement("option");
+    o.value = value;
+    o.textContent = label;
+    o.selected = selected;
+    select.appendChild(o);
+  }
+
+  function loadUsers() {
+    return api("GET", "/users").then(function (result) {
+      var list = $("users");
+      list.textContent = "";
+      Object.keys(result.body).sort().forEach(function (name) {
+        var li = document.createElement("li");
+        li.textContent = name;
+        li.className = name === state.user ? "selected" : "";
+        li.addEventListener("click", function () { edit(name); });
+        list.appendChild(li);
+      });
+      $("login").classList.add("hidden");
+      $("app").classList.remove("hidden");
+    });
+  }
+
+  function pathRow(path, allowed) {
+    var tr = document.createElement("tr");
+    var input = document.createElement("input");
+    input.type = "text";
+    input.value = path;
+    var check = document.createElement("input");
+    check.type = "checkbox";
+    check.checked = allowed;
+    var remove = document.createElement("button");
+    remove.textContent = "Remove";
+    remove.addEventListener("click", function () { tr.remove(); });
+    var label = document.createElement("label");
+    label.appendChild(check);
+    label.appendChild(document.createTextNode(" allowed"));
+    [input, label, remove].forEach(function (el) {
+      var td = document.createElement("td");
+      td.appendChild(el);
+      tr.appendChild(td);
+    });
+    $("paths").appendChild(tr);
+  }
+
+  function render(name, data) {
+    state.user = name;
+    state.data = data;
+    $("editor-title").textContent = name;
+    $("entrypoint").value = data.entrypoint || "";
+    $("paths").textContent = "";
+    var paths = data.paths || {};
+    Object.keys(paths).forEach(function (path) {
+      pathRow(path, !!(paths[path] && paths[path].allowed));
+    });
+    $("editor").classList.remove("hidden");
+
+    var sitemaps = data.sitemaps || {};
+    var allowed = sitemaps.allowed || [];
+    return api("GET", "/sitemaps?user=" + encodeURIComponent(name)).then(function (result) {
+      return result.body;
+    }, function (err) {
+      show(err.message, true);
+      return [];
+    }).then(function (available) {
+      var names = available.map(function (s) { return s.name; });
+      [sitemaps.default].concat(allowed).forEach(function (s) {
+        if (s && s !== "*" && names.indexOf(s) < 0) {
+          available.push({ name: s, label: s });
+          names.push(s);
+        }
+      });
+      $("default-sitemap").textContent = "";
+      $("allowed-sitemaps").textContent = "";
+      option($("allowed-sitemaps"), "*", "all sitemaps (*)", allowed.indexOf("*") >= 0);
+      available.forEach(function (s) {
+        var label = s.label && s.label !== s.name ? s.label + " (" + s.name + ")" : s.name;
+        option($("default-sitemap"), s.name, label, s.name === sitemaps.default);
+        option($("allowed-sitemaps"), s.name, label, allowed.indexOf(s.name) >= 0);
+      });
+      loadUsers();
+    });
+  }
+
+  function edit(name) {
+    show("");
+    api("GET", "/users/" + encodeURIComponent(name)).then(function (result) {
+      state.etag = result.resp.headers.get("ETag");
+      return render(name, result.body);
+    }).catch(function (err) { show(err.message, true); });
+  }
+
+  function collect() {
+    // fields without a form element are sent back unchanged
+    var data = JSON.parse(JSON.stringify(state.data));
+    data.entrypoint = $("entrypoint").value;
+    data.sitemaps = data.sitemaps || {};
+    data.sitemaps.default = $("default-sitemap").value;
+    data.sitemaps.allowed = Array.prototype.filter.call($("allowed-sitemaps").options, function (o) {
+      return o.selected;
+    }).map(function (o) { return o.value; });
+    var previous = data.paths || {};
+    var paths = {};
+    Array.prototype.forEach.call($("paths").children, function (tr) {
+      var inputs = tr.getElementsByTagName("input");
+      var path = inputs[0].value;
+      if (!path) {
+        return;
+      }
+      paths[path] = previous[path] || {};
+      paths[path].allowed = inputs[1].checked;
+    });
+    if (Object.keys(paths).length > 0) {
+      data.paths = paths;
+    } else {
+      delete data.paths;
+    }
+    return data;
+  }
+
+  $("signin").addEventListener("click", function () {
+    state.token = $("token").value;
+    sessionStorage.setItem("token", state.token);
+    loadUsers().then(function () { show(""); }, function (err) { show(err.message, true); });
+  });
+
+  $("create").addEventListener("click", function () {
+    var name = $("new-name").value;
+    if (!name) {
+      return;
+    }
+    state.etag = null;
+    $("new-name").value = "";
+    render(name, { entrypoint: "/start/index", sitemaps: { allowed: [] } });
+  });
+
+  $("add-path").addEventListener("click", function () { pathRow("", false); });
+
+  $("save").addEventListener("click", function () {
+    var headers = state.etag ? { "If-Match": state.etag } : { "If-None-Match": "*" };
+    api("PUT", "/users/" + encodeURIComponent(state.user), collect(), headers).then(function (result) {
+      state.etag = result.resp.headers.get("ETag");
+      state.data = result.body;
+      show("Saved " + state.user + ".");
+      loadUsers();
+    }).catch(function (err) { show(err.message, true); });
+  });
+
+  $("delete").addEventListener("click", function () {
+    if (!state.etag || !confirm("Delete " + state.user + "?")) {
+      return;
+    }
+    api("DELETE", "/users/" + encodeURIComponent(state.user), undefined, { "If-Match": state.etag }).then(function () {
+      show("Deleted " + state.user + ".");
+      state.user = null;
+      $("editor").classList.add("hidden");
+      loadUsers();
+    }).catch(function (err) { show(err.message, true); });
+  });
+
+  $("simulate").addEventListener("click", function () {
+    api("POST", "/simulate", {
+      user: $("sim-user").value,
+      method: $("sim-method").value,
+      url: $("sim-url").value,
+      client_ip: $("sim-ip").value,
+      accept: $("sim-accept").value,
+      body: $("sim-body").value
+    }).then(function (result) {
+      $("sim-result").textContent = JSON.stringify(result.body, null, 2);
+    }).catch(function (err) { $("sim-result").textContent = err.message; });
+  });
+
+  if (state.token) {
+    loadUsers().catch(function (err) { show(err.message, true); });
+  }
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const simulationTestConfig = `users:
+  john:
+    entrypoint: /start/index
+    sitemaps:
+      default: john
+      allowed:
+        - john
+    paths:
+      /paperui:
+        allowed: false
+      /habpanel:
+        allowed: true
+        networks:
+          - 192.168.1.0/24
+    items:
+      Door:
+        commands:
+          allowed:
+            - OPEN
+`
+
+func TestSimulate(t *testing.T) {
+	conf := &config.Main{}
+	assert.NoError(t, yaml.Unmarshal([]byte(simulationTestConfig), conf))
+	router, err := NewRouter(&Options{Target: "http://openhab:8080/openhab"}, validConfig(t, conf))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		simulation Simulation
+		expected   SimulationResult
+	}{
+		{
+			name:       "allowed sitemap",
+			simulation: Simulation{User: "john", URL: "/basicui/app?sitemap=john"},
+			expected:   SimulationResult{Decision: "allow", Status: http.StatusOK, Upstream: "default", URL: "/basicui/app?sitemap=john", Backend: "http://openhab:8080/openhab/basicui/app?sitemap=john"},
+		},
+		{
+			name:       "denied sitemap",
+			simulation: Simulation{User: "john", URL: "/basicui/app?sitemap=jane"},
+			expected:   SimulationResult{Decision: "deny", Status: http.StatusForbidden},
+		},
+		{
+			name:       "sitemap opened by a rest client",
+			simulation: Simulation{User: "john", URL: "/basicui/app?sitemap=jane", Accept: "application/json"},
+			expected:   SimulationResult{Decision: "rewrite", Status: http.StatusOK, Upstream: "default", URL: "/basicui/app?sitemap=john", Backend: "http://openhab:8080/openhab/basicui/app?sitemap=john"},
+		},
+		{
+			name:       "entrypoint",
+			simulation: Simulation{User: "john", URL: "/"},
+			expected:   SimulationResult{Decision: "rewrite", Status: http.StatusOK, Upstream: "default", URL: "/start/index", Backend: "http://openhab:8080/openhab/start/index"},
+		},
+		{
+			name:       "denied path",
+			simulation: Simulation{User: "john", URL: "/paperui/index.html"},
+			expected:   SimulationResult{Decision: "rewrite", Status: http.StatusOK, Upstream: "default", URL: "/start/index", Backend: "http://openhab:8080/openhab/start/index"},
+		},
+		{
+			name:       "path from another network",
+			simulation: Simulation{User: "john", URL: "/habpanel/index.html", ClientIP: "10.0.0.1"},
+			expected:   SimulationResult{Decision: "rewrite", Status: http.StatusOK, Upstream: "default", URL: "/start/index", Backend: "http://openhab:8080/openhab/start/index"},
+		},
+		{
+			name:       "path from the allowed network",
+			simulation: Simulation{User: "john", URL: "/habpanel/index.html", ClientIP: "192.168.1.10"},
+			expected:   SimulationResult{Decision: "allow", Status: http.StatusOK, Upstream: "default", URL: "/habpanel/index.html", Backend: "http://openhab:8080/openhab/habpanel/index.html"},
+		},
+		{
+			name:       "denied command",
+			simulation: Simulation{User: "john", Method: http.MethodPost, URL: "/rest/items/Door", Accept: "application/json", Body: "CLOSE"},
+			expected:   SimulationResult{Decision: "deny", Status: http.StatusForbidden, Detail: "The command 'CLOSE' is not allowed for item 'Door'."},
+		},
+		{
+			name:       "unknown user",
+			simulation: Simulation{User: "jane", URL: "/start/index", Accept: "application/json"},
+			expected:   SimulationResult{Decision: "deny", Status: http.StatusForbidden, Detail: "The user is not known to the router."},
+		},
+		{
+			name:       "missing user",
+			simulation: Simulation{URL: "/start/index", Accept: "text/plain"},
+			expected:   SimulationResult{Decision: "error", Status: http.StatusBadRequest, Detail: "the header 'X-Forwarded-Username' is either not set or empty"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := router.Simulate(&tt.simulation)
+			assert.NoError(t, err)
+			assert.Equal(t, &tt.expected, result)
+		})
+	}
+
+	_, err = router.Simulate(&Simulation{User: "john", URL: "http://openhab/start/index"})
+	assert.Error(t, err)
+	_, err = router.Simulate(&Simulation{User: "john", URL: "/start/index", ClientIP: "localhost"})
+	assert.Error(t, err)
+}
+
+func TestAdminServerServesUIWithoutToken(t *testing.T) {
+	admin, _, _, cleanup := makeAdminServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/ui/", nil)
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Type"), "text/html")
+	assert.NotEmpty(t, rr.Header().Get("Content-Security-Policy"))
+
+	rr = adminRequest(admin, http.MethodPost, "/simulate", `{"user":"john","url":"/start/index"}`, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"decision":"allow","status":200,"upstream":"default","url":"/start/index","backend":"http://openhab:8080/start/index"}`, rr.Body.String())
+}
+
+func TestAdminServerListsSitemaps(t *testing.T) {
+	openhab := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/rest/sitemaps", req.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"name":"john","label":"John's home","link":"http://openhab/rest/sitemaps/john"}]`))
+	}))
+	defer openhab.Close()
+
+	admin, router, _, cleanup := makeAdminServer(t)
+	defer cleanup()
+	pool, err := NewPool(config.DefaultUpstream, []string{openhab.URL})
+	assert.NoError(t, err)
+	router.Upstreams = map[string]*Pool{config.DefaultUpstream: pool}
+
+	rr := adminRequest(admin, http.MethodGet, "/sitemaps?user=john", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[{"name":"john","label":"John's home"}]`, rr.Body.String())
+}
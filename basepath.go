@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// matches absolute paths in HTML attributes, but not protocol relative URLs
+	htmlPathPattern = regexp.MustCompile(`((?:href|src|action)\s*=\s*["'])(/[^/"'])`)
+
+	// matches the path of cookies
+	cookiePathPattern = regexp.MustCompile(`(?i)(;\s*path=)(/[^;]*)`)
+)
+
+// stripBasePath removes the base path from the request, so openHAB receives
+// the path it expects; requests outside of the base path are left untouched
+func stripBasePath(req *http.Request, basePath string) {
+	if basePath == "" {
+		return
+	}
+
+	path := req.URL.Path
+	if path != basePath && !strings.HasPrefix(path, basePath+"/") {
+		return
+	}
+
+	req.URL.Path = strings.TrimPrefix(path, basePath)
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = strings.TrimPrefix(req.URL.RawPath, basePath)
+	}
+}
+
+// rewriteBasePath adds the base path to the links openHAB sends back, as it
+// only knows about absolute paths like `/basicui/app`
+func rewriteBasePath(resp *http.Response, basePath string) error {
+	if basePath == "" {
+		return nil
+	}
+	host := resp.Request.Host
+
+	if location := resp.Header.Get("Location"); location != "" {
+		resp.Header.Set("Location", prefixLocation(location, host, basePath))
+	}
+
+	if cookies := resp.Header["Set-Cookie"]; len(cookies) > 0 {
+		for i, cookie := range cookies {
+			cookies[i] = cookiePathPattern.ReplaceAllString(cookie, "${1}"+basePath+"${2}")
+		}
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	isHTML := strings.Contains(contentType, "text/html")
+	isJSON := strings.Contains(contentType, "application/json")
+	if !isHTML && !isJSON || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	if isJSON {
+		if host == "" {
+			return nil
+		}
+		// links in the REST API are absolute URLs, e.g. `"link":"http://home/rest/items/Light"`;
+		// listings can be large, so they are rewritten while they are read
+		var links, prefixed [][]byte
+		for _, scheme := range []string{"http", "https"} {
+			links = append(links, []byte(`"`+scheme+"://"+host+"/"))
+			prefixed = append(prefixed, []byte(`"`+scheme+"://"+host+basePath+"/"))
+		}
+		resp.Body = streamReplaced(resp.Body, links, prefixed)
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	body = htmlPathPattern.ReplaceAll(body, []byte("${1}"+basePath+"${2}"))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	return nil
+}
+
+// streamReplaced replaces each of from by the corresponding to while the body is read
+func streamReplaced(body io.ReadCloser, from [][]byte, to [][]byte) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		defer body.Close()
+		_ = writer.CloseWithError(copyReplaced(writer, body, from, to))
+	}()
+	return reader
+}
+
+func copyReplaced(dst io.Writer, src io.Reader, from [][]byte, to [][]byte) error {
+	// the end of a chunk may hold the beginning of a match, it is kept for the next one
+	keep := 0
+	for _, o := range from {
+		if len(o)-1 > keep {
+			keep = len(o) - 1
+		}
+	}
+
+	chunk := make([]byte, 32*1024)
+	var pending []byte
+	for {
+		n, readErr := src.Read(chunk)
+		pending = append(pending, chunk[:n]...)
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+
+		safe := len(pending) - keep
+		if readErr == io.EOF {
+			safe = len(pending)
+		}
+		// matches starting before safe are complete
+		i := 0
+		for i < safe {
+			at, match := nextMatch(pending[i:], from)
+			if match < 0 || i+at >= safe {
+				at = safe - i
+			}
+			if _, err := dst.Write(pending[i : i+at]); err != nil {
+				return err
+			}
+			i += at
+			if i < safe {
+				if _, err := dst.Write(to[match]); err != nil {
+					return err
+				}
+				i += len(from[match])
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if i > 0 {
+			pending = append(pending[:0], pending[i:]...)
+		}
+	}
+}
+
+// nextMatch finds the first occurrence of any of the patterns
+func nextMatch(data []byte, patterns [][]byte) (at int, match int) {
+	at, match = -1, -1
+	for i, pattern := range patterns {
+		if index := bytes.Index(data, pattern); index >= 0 && (at < 0 || index < at) {
+			at, match = index, i
+		}
+	}
+	return at, match
+}
+
+func prefixLocation(location string, host string, basePath string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	if u.Host != "" && u.Host != host {
+		return location
+	}
+	if !strings.HasPrefix(u.Path, "/") {
+		return location
+	}
+	u.Path = basePath + u.Path
+	if u.RawPath != "" {
+		u.RawPath = basePath + u.RawPath
+	}
+	return u.String()
+}
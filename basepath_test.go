@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_stripBasePath(t *testing.T) {
+	tests := []struct {
+		uri      string
+		expected string
+	}{
+		{uri: "/openhab/basicui/app?sitemap=demo", expected: "/basicui/app?sitemap=demo"},
+		{uri: "/openhab", expected: "/"},
+		{uri: "/openhab/", expected: "/"},
+		{uri: "/openhabian/index.html", expected: "/openhabian/index.html"},
+		{uri: "/rest/items", expected: "/rest/items"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			req := makeGETRequest(tt.uri, "test")
+			stripBasePath(req, "/openhab")
+			assert.Equal(t, tt.expected, req.URL.RequestURI())
+		})
+	}
+}
+
+func Test_prefixLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		expected string
+	}{
+		{location: "/basicui/app?sitemap=demo", expected: "/openhab/basicui/app?sitemap=demo"},
+		{location: "http://home.example.org/start/index", expected: "http://home.example.org/openhab/start/index"},
+		{location: "https://example.com/start/index", expected: "https://example.com/start/index"},
+		{location: "app?sitemap=demo", expected: "app?sitemap=demo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			assert.Equal(t, tt.expected, prefixLocation(tt.location, "home.example.org", "/openhab"))
+		})
+	}
+}
+
+func TestResponsesAreRewrittenUnderBasePath(t *testing.T) {
+	_, mux, closeRemote := testRouter(t, &config.Main{Passthrough: true, BasePath: "/openhab"}, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start/index":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1", Path: "/"})
+			w.Header().Set("Location", "/basicui/app")
+			w.WriteHeader(http.StatusFound)
+		case "/basicui/app":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(`<link href="/basicui/mdl.css"><script src='/basicui/app.js'></script><a href="//cdn.example.org/x">`))
+		case "/rest/items/Light":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"link":"http://` + r.Host + `/rest/items/Light","name":"Light"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	req := makeGETRequest("/openhab/start/index", "")
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "/openhab/basicui/app", rr.Header().Get("Location"))
+	assert.Equal(t, "session=1; Path=/openhab/", rr.Header().Get("Set-Cookie"))
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/openhab/basicui/app", ""))
+	assert.Equal(t, `<link href="/openhab/basicui/mdl.css"><script src='/openhab/basicui/app.js'></script><a href="//cdn.example.org/x">`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	req = makeGETRequest("/openhab/rest/items/Light", "")
+	req.Host = "home.example.org"
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, `{"link":"http://home.example.org/openhab/rest/items/Light","name":"Light"}`, rr.Body.String())
+}
+
+func Test_copyReplaced(t *testing.T) {
+	from := [][]byte{[]byte(`"http://home/`), []byte(`"https://home/`)}
+	to := [][]byte{[]byte(`"http://home/openhab/`), []byte(`"https://home/openhab/`)}
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{name: "without links", body: `[{"name":"Light"}]`, expected: `[{"name":"Light"}]`},
+		{name: "links", body: `["http://home/rest","https://home/rest"]`, expected: `["http://home/openhab/rest","https://home/openhab/rest"]`},
+		{name: "link at the end", body: `"http://home/`, expected: `"http://home/openhab/`},
+		{name: "partial link at the end", body: `["http://hom`, expected: `["http://hom`},
+		{name: "other host", body: `"http://homer/"`, expected: `"http://homer/"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// links are split across reads
+			var out bytes.Buffer
+			assert.NoError(t, copyReplaced(&out, iotest.OneByteReader(strings.NewReader(tt.body)), from, to))
+			assert.Equal(t, tt.expected, out.String())
+
+			out.Reset()
+			assert.NoError(t, copyReplaced(&out, strings.NewReader(tt.body), from, to))
+			assert.Equal(t, tt.expected, out.String())
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+)
+
+// maxCommandBodySize limits how much of a command request is read for inspection
+const maxCommandBodySize = 64 * 1024
+
+// itemCommands extracts the item commands carried by a request.
+//
+// openHAB accepts commands as plain text body on `POST /rest/items/<item>`,
+// state updates on `PUT /rest/items/<item>/state` and Basic UI sends
+// `item=command` form values to `/basicui/CMD`.
+// The request body is restored, so it can still be forwarded.
+func itemCommands(req *http.Request) (map[string]string, error) {
+	commands := map[string]string{}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	switch {
+	case req.Method == http.MethodPost && len(parts) == 3 && parts[0] == "rest" && parts[1] == "items",
+		req.Method == http.MethodPut && len(parts) == 4 && parts[0] == "rest" && parts[1] == "items" && parts[3] == "state":
+		body, err := readBody(req)
+		if err != nil {
+			return nil, err
+		}
+		commands[parts[2]] = strings.TrimSpace(string(body))
+	case strings.HasPrefix(req.URL.Path, "/basicui/CMD"):
+		values := req.URL.Query()
+		if req.Method == http.MethodPost && strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			body, err := readBody(req)
+			if err != nil {
+				return nil, err
+			}
+			form, err := url.ParseQuery(string(body))
+			if err != nil {
+				return nil, err
+			}
+			for key, value := range form {
+				values[key] = append(values[key], value...)
+			}
+		}
+		for item, value := range values {
+			// control fields such as `__async` or `__cmd` do not name an item
+			if strings.HasPrefix(item, "__") || len(value) == 0 {
+				continue
+			}
+			commands[item] = value[len(value)-1]
+		}
+	}
+
+	return commands, nil
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, req.Body, maxCommandBodySize))
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// commandAllowed checks whether the user may send the given command to an item
+func commandAllowed(user *config.User, item string, command string) bool {
+	if !user.ItemAllowed(item) {
+		return false
+	}
+
+	itemConfig, ok := user.Items[item]
+	if !ok || itemConfig == nil || itemConfig.Commands == nil {
+		return true
+	}
+
+	constraint := itemConfig.Commands
+	if len(constraint.Allowed) > 0 {
+		found := false
+		for _, allowed := range constraint.Allowed {
+			if command == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if constraint.Min != nil || constraint.Max != nil {
+		// quantity types carry their unit, e.g. `21.5 °C`; the bounds only apply to
+		// the configured unit, as openHAB converts others, e.g. `5 kW` to `5000 W`
+		fields := strings.Fields(command)
+		if len(fields) == 0 || len(fields) > 2 {
+			return false
+		}
+		if len(fields) == 2 && fields[1] != constraint.Unit {
+			return false
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return false
+		}
+		if constraint.Min != nil && value < *constraint.Min {
+			return false
+		}
+		if constraint.Max != nil && value > *constraint.Max {
+			return false
+		}
+	}
+
+	if constraint.Pattern != "" && !constraint.MatchesPattern(command) {
+		return false
+	}
+
+	return true
+}
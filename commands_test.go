@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_itemCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *http.Request
+		expected map[string]string
+	}{
+		{
+			name:     "rest - command sent to an item",
+			req:      makeRequest("POST", "/rest/items/Light_Kids", "text/plain", "ON"),
+			expected: map[string]string{"Light_Kids": "ON"},
+		},
+		{
+			name:     "rest - state update sent to an item",
+			req:      makeRequest("PUT", "/rest/items/Thermostat_Setpoint/state", "text/plain", "25"),
+			expected: map[string]string{"Thermostat_Setpoint": "25"},
+		},
+		{
+			name:     "rest - reading an item carries no command",
+			req:      makeRequest("GET", "/rest/items/Light_Kids", "", ""),
+			expected: map[string]string{},
+		},
+		{
+			name:     "basicui - command sent as query",
+			req:      makeRequest("GET", "/basicui/CMD?Light_Kids=ON&__async=true", "", ""),
+			expected: map[string]string{"Light_Kids": "ON"},
+		},
+		{
+			name:     "basicui - command sent as form post",
+			req:      makeRequest("POST", "/basicui/CMD", "application/x-www-form-urlencoded", "Thermostat_Setpoint=25&__cmd=true"),
+			expected: map[string]string{"Thermostat_Setpoint": "25"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, err := itemCommands(tt.req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, commands)
+		})
+	}
+}
+
+func TestItemCommandsRestoresBody(t *testing.T) {
+	req := makeRequest("POST", "/rest/items/Light_Kids", "text/plain", "ON")
+	_, err := itemCommands(req)
+	assert.NoError(t, err)
+
+	body, err := readBody(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "ON", string(body))
+}
+
+func Test_commandAllowed(t *testing.T) {
+	denied := false
+	min := 16.0
+	max := 23.0
+	maxPower := 2000.0
+	user := &config.User{
+		Entrypoint: "/basicui/app",
+		Sitemaps:   config.Sitemap{Default: "demo", Allowed: []string{"demo"}},
+		Items: map[string]*config.Item{
+			"Light_Kids":          {Commands: &config.Command{Allowed: []string{"ON", "OFF"}}},
+			"Thermostat_Setpoint": {Commands: &config.Command{Min: &min, Max: &max, Unit: "°C"}},
+			"Heater_Power":        {Commands: &config.Command{Max: &maxPower}},
+			"Scene":               {Commands: &config.Command{Pattern: "[0-3]"}},
+			"Alarm":               {Allowed: &denied},
+		},
+	}
+	validConfig(t, &config.Main{Users: map[string]*config.User{"test": user}})
+
+	tests := []struct {
+		name     string
+		item     string
+		command  string
+		expected bool
+	}{
+		{name: "unconfigured item", item: "Light_Living", command: "ON", expected: true},
+		{name: "denied item", item: "Alarm", command: "OFF", expected: false},
+		{name: "enumerated value", item: "Light_Kids", command: "ON", expected: true},
+		{name: "value not enumerated", item: "Light_Kids", command: "50", expected: false},
+		{name: "value in range", item: "Thermostat_Setpoint", command: "21.5", expected: true},
+		{name: "value with unit in range", item: "Thermostat_Setpoint", command: "21.5 °C", expected: true},
+		{name: "value with other unit", item: "Thermostat_Setpoint", command: "280 K", expected: false},
+		{name: "value with unit without configured unit", item: "Heater_Power", command: "5 kW", expected: false},
+		{name: "value without unit without configured unit", item: "Heater_Power", command: "1500", expected: true},
+		{name: "value above max", item: "Thermostat_Setpoint", command: "23.5", expected: false},
+		{name: "value below min", item: "Thermostat_Setpoint", command: "12", expected: false},
+		{name: "value not numeric", item: "Thermostat_Setpoint", command: "INCREASE", expected: false},
+		{name: "value matches pattern", item: "Scene", command: "2", expected: true},
+		{name: "pattern is anchored", item: "Scene", command: "12", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, commandAllowed(user, tt.item, tt.command))
+		})
+	}
+}
+
+func TestDeniedCommandIsBlocked(t *testing.T) {
+	req := makeRequest("POST", "/rest/items/Light_Kids", "text/plain", "50")
+	req.Header.Add("X-Forwarded-Username", "test")
+
+	conf := validConfig(t, &config.Main{
+		Passthrough: false,
+		Users: map[string]*config.User{
+			"test": {
+				Entrypoint: "/basicui/app",
+				Sitemaps:   config.Sitemap{Default: "demo", Allowed: []string{"demo"}},
+				Items: map[string]*config.Item{
+					"Light_Kids": {Commands: &config.Command{Allowed: []string{"ON", "OFF"}}},
+				},
+			},
+		},
+	})
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mainHandler(w, r, defaultErrorPages(), conf, nil)
+	})
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func makeRequest(method string, uri string, contentType string, body string) *http.Request {
+	r, _ := http.NewRequest(method, uri, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
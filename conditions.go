@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/rs/zerolog/log"
+)
+
+// now is the clock conditions are evaluated against; tests replace it
+var now = time.Now
+
+// conditionsMet checks the conditions of a user or rule for the given request
+// and names the condition which failed
+func conditionsMet(conditions config.Conditions, req *http.Request, conf *config.Main) (bool, string) {
+	if !conditions.InWindow(now()) {
+		return false, "schedule"
+	}
+
+	if len(conditions.Networks) > 0 && !conditions.InNetwork(clientIP(req, conf)) {
+		return false, "network"
+	}
+
+	return true, ""
+}
+
+// clientIP determines the address of the client.
+//
+// The address of the connection is used, unless it belongs to a trusted proxy;
+// then the `X-Forwarded-For` chain is followed from the right to the first
+// address which is not a trusted proxy. Connections to the Unix socket have
+// no address, they come from a local proxy whose header is always trusted.
+func clientIP(req *http.Request, conf *config.Main) net.IP {
+	forwarded := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+	i := len(forwarded) - 1
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		if !fromUnixSocket(req) {
+			return nil
+		}
+		if ip = net.ParseIP(strings.TrimSpace(forwarded[i])); ip == nil {
+			return nil
+		}
+		i--
+	}
+
+	trusted := conf.TrustedNetworks()
+	if len(trusted) == 0 {
+		return ip
+	}
+
+	for ; i >= 0 && isTrusted(ip, trusted); i-- {
+		next := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if next == nil {
+			break
+		}
+		ip = next
+	}
+
+	return ip
+}
+
+// fromUnixSocket reports whether the request was received on a Unix socket
+func fromUnixSocket(req *http.Request) bool {
+	addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sitemapAllowed checks the sitemap against the allowed sitemaps of the user
+// and the conditions of its rule
+func sitemapAllowed(user *config.User, sitemap string, req *http.Request, conf *config.Main) bool {
+	if rule, ok := user.Sitemaps.Rules[sitemap]; ok && rule != nil {
+		if met, condition := conditionsMet(rule.Conditions, req, conf); !met {
+			log.Debug().Str("user", req.Header.Get("X-Forwarded-Username")).Str("sitemap", sitemap).Str("condition", condition).Msg("sitemap rule condition not met")
+			return false
+		}
+	}
+
+	if len(user.Sitemaps.Allowed) == 1 && user.Sitemaps.Allowed[0] == "*" {
+		return true
+	}
+	for _, allowedSitemap := range user.Sitemaps.Allowed {
+		if sitemap == allowedSitemap {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// tuesday morning within the cleaners window
+var tuesdayMorning = time.Date(2020, 3, 3, 9, 30, 0, 0, time.UTC)
+
+// wednesday morning outside of the cleaners window
+var wednesdayMorning = time.Date(2020, 3, 4, 9, 30, 0, 0, time.UTC)
+
+func setClock(t time.Time) func() {
+	now = func() time.Time { return t }
+	return func() { now = time.Now }
+}
+
+func cleanerConfig(t *testing.T) *config.Main {
+	window := []*config.Window{{Weekdays: []string{"tue"}, From: "08:00", To: "12:00", Timezone: "UTC"}}
+	return validConfig(t, &config.Main{
+		Passthrough: false,
+		Users: map[string]*config.User{
+			"test": {
+				Entrypoint: "/basicui/app",
+				Sitemaps: config.Sitemap{
+					Default: "cleaning",
+					Allowed: []string{"cleaning", "alarm"},
+					Rules: map[string]*config.SitemapRule{
+						"alarm": {Conditions: config.Conditions{Windows: window}},
+					},
+				},
+				Paths: map[string]*config.Path{
+					"/habpanel": {Allowed: true, Conditions: config.Conditions{Windows: window}},
+				},
+			},
+		},
+	})
+}
+
+func Test_ruleDirectorWithSchedules(t *testing.T) {
+	tests := []struct {
+		name               string
+		time               time.Time
+		req                *http.Request
+		expectedRequestURI string
+	}{
+		{
+			name:               "basicui - sitemap is accessible within its window",
+			time:               tuesdayMorning,
+			req:                makeGETRequest("/basicui/app?sitemap=alarm", "test"),
+			expectedRequestURI: "/basicui/app?sitemap=alarm",
+		},
+		{
+			name:               "basicui - user is redirected to default sitemap outside of the window",
+			time:               wednesdayMorning,
+			req:                makeGETRequest("/basicui/app?sitemap=alarm", "test"),
+			expectedRequestURI: "/basicui/app?sitemap=cleaning",
+		},
+		{
+			name:               "rest - sitemap is accessible within its window",
+			time:               tuesdayMorning,
+			req:                makeGETRequest("/rest/sitemaps/alarm", "test"),
+			expectedRequestURI: "/rest/sitemaps/alarm",
+		},
+		{
+			name:               "rest - user is redirected to default sitemap outside of the window",
+			time:               wednesdayMorning,
+			req:                makeGETRequest("/rest/sitemaps/alarm", "test"),
+			expectedRequestURI: "/rest/sitemaps/cleaning",
+		},
+		{
+			name:               "path is accessible within its window",
+			time:               tuesdayMorning,
+			req:                makeGETRequest("/habpanel/index.html", "test"),
+			expectedRequestURI: "/habpanel/index.html",
+		},
+		{
+			name:               "user is forced to entrypoint outside of the path window",
+			time:               wednesdayMorning,
+			req:                makeGETRequest("/habpanel/index.html", "test"),
+			expectedRequestURI: "/basicui/app?sitemap=cleaning",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setClock(tt.time)()
+			ruleDirector(tt.req, cleanerConfig(t))
+			assert.Equal(t, tt.expectedRequestURI, tt.req.URL.RequestURI())
+		})
+	}
+}
+
+func TestUserOutsideOfWindowIsBlocked(t *testing.T) {
+	defer setClock(wednesdayMorning)()
+
+	conf := cleanerConfig(t)
+	conf.Users["test"].Windows = []*config.Window{{Weekdays: []string{"tue"}}}
+	validConfig(t, conf)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mainHandler(w, r, defaultErrorPages(), conf, nil)
+	})
+	handler.ServeHTTP(rr, makeGETRequest("/basicui/app", "test"))
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func Test_clientIP(t *testing.T) {
+	conf := validConfig(t, &config.Main{Passthrough: true, TrustedProxies: []string{"10.0.0.0/8"}})
+	tests := []struct {
+		name       string
+		remoteAddr string
+		unix       bool
+		forwarded  []string
+		expected   string
+	}{
+		{
+			name:       "connection address is used without forwarded header",
+			remoteAddr: "192.168.1.10:51234",
+			expected:   "192.168.1.10",
+		},
+		{
+			name:       "forwarded header of an untrusted client is ignored",
+			remoteAddr: "203.0.113.7:51234",
+			forwarded:  []string{"192.168.1.10"},
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "forwarded header of a trusted proxy is followed",
+			remoteAddr: "10.0.0.2:51234",
+			forwarded:  []string{"192.168.1.10"},
+			expected:   "192.168.1.10",
+		},
+		{
+			name:       "forwarded chain is followed to the first untrusted address",
+			remoteAddr: "10.0.0.2:51234",
+			forwarded:  []string{"192.168.1.10, 203.0.113.7, 10.0.0.3"},
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "multiple forwarded headers form one chain",
+			remoteAddr: "10.0.0.2:51234",
+			forwarded:  []string{"203.0.113.7", "10.0.0.3"},
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "forwarded header of a Unix socket peer is trusted",
+			remoteAddr: "@",
+			unix:       true,
+			forwarded:  []string{"192.168.1.10"},
+			expected:   "192.168.1.10",
+		},
+		{
+			name:       "forwarded chain of a Unix socket peer is followed to the first untrusted address",
+			remoteAddr: "@",
+			unix:       true,
+			forwarded:  []string{"203.0.113.7, 10.0.0.3"},
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "Unix socket peer without forwarded header has no address",
+			remoteAddr: "@",
+			unix:       true,
+			expected:   "<nil>",
+		},
+		{
+			name:       "forwarded header without connection address is ignored",
+			remoteAddr: "@",
+			forwarded:  []string{"192.168.1.10"},
+			expected:   "<nil>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := makeGETRequest("/", "test")
+			if tt.unix {
+				addr := &net.UnixAddr{Name: "/run/openhab-auth-router.sock", Net: "unix"}
+				req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, addr))
+			}
+			req.RemoteAddr = tt.remoteAddr
+			for _, forwarded := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", forwarded)
+			}
+			assert.Equal(t, tt.expected, clientIP(req, conf).String())
+		})
+	}
+}
+
+func Test_ruleDirectorWithNetworks(t *testing.T) {
+	conf := validConfig(t, &config.Main{
+		Passthrough: false,
+		Users: map[string]*config.User{
+			"test": {
+				Entrypoint: "/basicui/app",
+				Sitemaps: config.Sitemap{
+					Default: "demo",
+					Allowed: []string{"*"},
+					Rules: map[string]*config.SitemapRule{
+						"alarm": {Conditions: config.Conditions{Networks: []string{"192.168.1.0/24"}}},
+					},
+				},
+			},
+		},
+	})
+	tests := []struct {
+		name               string
+		remoteAddr         string
+		expectedRequestURI string
+	}{
+		{
+			name:               "sitemap is accessible from the home network",
+			remoteAddr:         "192.168.1.10:51234",
+			expectedRequestURI: "/basicui/app?sitemap=alarm",
+		},
+		{
+			name:               "user is redirected to default sitemap from other networks",
+			remoteAddr:         "203.0.113.7:51234",
+			expectedRequestURI: "/basicui/app?sitemap=demo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := makeGETRequest("/basicui/app?sitemap=alarm", "test")
+			req.RemoteAddr = tt.remoteAddr
+			ruleDirector(req, conf)
+			assert.Equal(t, tt.expectedRequestURI, req.URL.RequestURI())
+		})
+	}
+}
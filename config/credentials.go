@@ -0,0 +1,118 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Credentials the router authenticates with at openHAB, either an API token
+// or username and password; secrets can be read from files or environment variables
+type Credentials struct {
+	Token        string `yaml:"token"`
+	TokenFile    string `yaml:"token_file"`
+	TokenEnv     string `yaml:"token_env"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	PasswordEnv  string `yaml:"password_env"`
+}
+
+// Authorization is the value of the `Authorization` header for the credentials
+func (c *Credentials) Authorization() string {
+	if c.Username != "" {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+	}
+	return "Bearer " + c.Token
+}
+
+// Resolve reads the secrets of the credentials from their files or environment variables
+func (c *Credentials) Resolve() error {
+	var err error
+	if c.Token, err = resolveSecret(c.Token, c.TokenFile, c.TokenEnv); err != nil {
+		return fmt.Errorf("failed to resolve token: %s", err)
+	}
+	if c.Password, err = resolveSecret(c.Password, c.PasswordFile, c.PasswordEnv); err != nil {
+		return fmt.Errorf("failed to resolve password: %s", err)
+	}
+	return nil
+}
+
+func resolveSecret(value string, file string, env string) (string, error) {
+	switch {
+	case file != "":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	case env != "":
+		secret, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("environment variable '%s' is not set", env)
+		}
+		return secret, nil
+	}
+	return value, nil
+}
+
+// ResolveCredentials resolves the secrets of all configured credentials
+func ResolveCredentials(config *Main) error {
+	if config.UpstreamAuth != nil {
+		if err := config.UpstreamAuth.Resolve(); err != nil {
+			return fmt.Errorf("The field `upstream_auth` is invalid: %s", err)
+		}
+	}
+
+	if sign := config.Headers.Sign; sign != nil {
+		secret, err := resolveSecret(sign.Secret, sign.SecretFile, sign.SecretEnv)
+		if err != nil {
+			return fmt.Errorf("The field `headers.sign` is invalid: failed to resolve secret: %s", err)
+		}
+		sign.Secret = secret
+	}
+
+	for user, userData := range config.Users {
+		if userData.UpstreamAuth == nil {
+			continue
+		}
+		if err := userData.UpstreamAuth.Resolve(); err != nil {
+			return fmt.Errorf("The field `upstream_auth` of user '%s' is invalid: %s", user, err)
+		}
+	}
+
+	return nil
+}
+
+func validateCredentials(c *Credentials) error {
+	if c == nil {
+		return nil
+	}
+
+	tokenSources := countSet(c.Token, c.TokenFile, c.TokenEnv)
+	passwordSources := countSet(c.Password, c.PasswordFile, c.PasswordEnv)
+	switch {
+	case tokenSources > 1:
+		return fmt.Errorf("only one of `token`, `token_file` and `token_env` can be set")
+	case passwordSources > 1:
+		return fmt.Errorf("only one of `password`, `password_file` and `password_env` can be set")
+	case tokenSources == 1 && c.Username != "":
+		return fmt.Errorf("either a token or a username can be set")
+	case tokenSources == 0 && c.Username == "":
+		return fmt.Errorf("either a token or a username is required")
+	}
+
+	return nil
+}
+
+func countSet(values ...string) int {
+	count := 0
+	for _, value := range values {
+		if value != "" {
+			count++
+		}
+	}
+	return count
+}
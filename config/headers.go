@@ -0,0 +1,24 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"text/template"
+)
+
+// Templates returns the templates of `add` by canonical header name, as parsed by Validate
+func (h *Headers) Templates() map[string]*template.Template {
+	return h.templates
+}
+
+func parseHeaderTemplates(headers map[string]string) (map[string]*template.Template, error) {
+	templates := map[string]*template.Template{}
+	for name, value := range headers {
+		tmpl, err := template.New(name).Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("the template of header '%s' is invalid: %s", name, err)
+		}
+		templates[http.CanonicalHeaderKey(name)] = tmpl
+	}
+	return templates, nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// variableName is the name of an environment variable in `${NAME}`
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Interpolate replaces the placeholders in a value of the config:
+//
+//	${NAME}              the environment variable, which has to be set
+//	${NAME:-default}     the environment variable or the default, if it is unset or empty
+//	${file:/path}        the content of the file without surrounding whitespace
+//
+// `$${` is kept as a literal `${`. Values are interpolated after the file
+// was parsed, so whatever they contain is never parsed as part of the file.
+func Interpolate(value string) (string, error) {
+	var out strings.Builder
+	for {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			out.WriteString(value)
+			return out.String(), nil
+		}
+		if start > 0 && value[start-1] == '$' {
+			out.WriteString(value[:start-1] + "${")
+			value = value[start+2:]
+			continue
+		}
+		end := strings.Index(value[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("the placeholder '%s' is not closed", strings.TrimSpace(value[start:]))
+		}
+		resolved, err := resolvePlaceholder(value[start+2 : start+end])
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(value[:start] + resolved)
+		value = value[start+end+1:]
+	}
+}
+
+func resolvePlaceholder(placeholder string) (string, error) {
+	if strings.HasPrefix(placeholder, "file:") {
+		path := strings.TrimPrefix(placeholder, "file:")
+		if path == "" {
+			return "", fmt.Errorf("the placeholder '${file:}' is missing the path")
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read '%s': %s", path, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	name, fallback := placeholder, ""
+	hasFallback := false
+	if i := strings.Index(placeholder, ":-"); i >= 0 {
+		name, fallback, hasFallback = placeholder[:i], placeholder[i+2:], true
+	}
+	if !variableName.MatchString(name) {
+		return "", fmt.Errorf("the placeholder '${%s}' is invalid, expected '${NAME}', '${NAME:-default}' or '${file:/path}'", placeholder)
+	}
+	value, ok := os.LookupEnv(name)
+	switch {
+	case hasFallback && value == "":
+		return fallback, nil
+	case !ok:
+		return "", fmt.Errorf("environment variable '%s' is not set", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "interpolate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("OPENHAB_AUTH_ROUTER_TEST_TOKEN", "env-token")
+	defer os.Unsetenv("OPENHAB_AUTH_ROUTER_TEST_TOKEN")
+	os.Setenv("OPENHAB_AUTH_ROUTER_TEST_EMPTY", "")
+	defer os.Unsetenv("OPENHAB_AUTH_ROUTER_TEST_EMPTY")
+
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+		wantErr  string
+	}{
+		{
+			name:     "environment variable",
+			data:     "${OPENHAB_AUTH_ROUTER_TEST_TOKEN}",
+			expected: "env-token",
+		},
+		{
+			name:     "several placeholders in a value",
+			data:     "http://${OPENHAB_AUTH_ROUTER_TEST_MISSING:-openhab}:${OPENHAB_AUTH_ROUTER_TEST_MISSING:-8080}",
+			expected: "http://openhab:8080",
+		},
+		{
+			name:     "default of an empty variable",
+			data:     "${OPENHAB_AUTH_ROUTER_TEST_EMPTY:-5}",
+			expected: "5",
+		},
+		{
+			name:     "set empty variable",
+			data:     "${OPENHAB_AUTH_ROUTER_TEST_EMPTY}",
+			expected: "",
+		},
+		{
+			name:     "file",
+			data:     "${file:" + secretFile + "}",
+			expected: "file-secret",
+		},
+		{
+			name:     "escaped placeholder",
+			data:     "$${OPENHAB_AUTH_ROUTER_TEST_TOKEN}",
+			expected: "${OPENHAB_AUTH_ROUTER_TEST_TOKEN}",
+		},
+		{
+			name:     "dollar signs without braces",
+			data:     `^\d+$`,
+			expected: `^\d+$`,
+		},
+		{
+			name:    "missing variable",
+			data:    "${OPENHAB_AUTH_ROUTER_TEST_MISSING}",
+			wantErr: "environment variable 'OPENHAB_AUTH_ROUTER_TEST_MISSING' is not set",
+		},
+		{
+			name:    "missing file",
+			data:    "${file:" + filepath.Join(dir, "missing") + "}",
+			wantErr: "failed to read",
+		},
+		{
+			name:    "unclosed placeholder",
+			data:    "${OPENHAB_AUTH_ROUTER_TEST_TOKEN",
+			wantErr: "is not closed",
+		},
+		{
+			name:    "invalid name",
+			data:    "${not a name}",
+			wantErr: "is invalid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Interpolate(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Interpolate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Interpolate() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Interpolate() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
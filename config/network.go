@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// ParseNetworks parses a list of CIDR notations, e.g. `192.168.1.0/24`
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network '%s', expected CIDR notation like '192.168.1.0/24'", cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// InNetwork reports whether the address lies within any of the networks parsed
+// by Validate; without networks, there is no restriction
+func (c *Conditions) InNetwork(ip net.IP) bool {
+	if len(c.Networks) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, network := range c.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustedNetworks returns the networks of `trusted_proxies`, as parsed by Validate
+func (m *Main) TrustedNetworks() []*net.IPNet {
+	return m.trustedProxies
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationPattern matches the durations of Go like `90s` or `1h30m`
+const durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// Schema describes the config as JSON Schema, derived from the yaml tags of the types
+func Schema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Main{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "openhab-auth-router config"
+
+	properties := schema["properties"].(map[string]interface{})
+	// JSON files refer to the schema in the file itself
+	properties["$schema"] = map[string]interface{}{"type": "string"}
+	// YAML reads an unquoted `openhab_version: 3` as a number
+	versions := []interface{}{}
+	for _, version := range OpenHABVersions {
+		number, _ := strconv.ParseFloat(version, 64)
+		versions = append(versions, version, number)
+	}
+	properties["openhab_version"] = map[string]interface{}{"enum": versions}
+	properties["error_pages"].(map[string]interface{})["propertyNames"] = map[string]interface{}{
+		"pattern": "^[45][0-9][0-9]$",
+	}
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		// numbers are read as nanoseconds
+		return map[string]interface{}{"type": []string{"string", "integer"}, "pattern": durationPattern}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		// an empty value like `john:` is decoded as nil
+		return map[string]interface{}{"anyOf": []interface{}{typeSchema(t.Elem()), map[string]interface{}{"type": "null"}}}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		addProperties(t, properties)
+		return map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+	}
+	return map[string]interface{}{}
+}
+
+func addProperties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("yaml")
+		if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if strings.Contains(tag, ",inline") {
+			addProperties(field.Type, properties)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		properties[name] = typeSchema(field.Type)
+	}
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	schema := Schema()
+	properties := schema["properties"].(map[string]interface{})
+	for _, name := range []string{"include", "users", "rate_limits", "error_pages"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("Schema() is missing the property %s", name)
+		}
+	}
+
+	user := properties["users"].(map[string]interface{})["additionalProperties"].(map[string]interface{})["anyOf"].([]interface{})[0].(map[string]interface{})
+	userProperties := user["properties"].(map[string]interface{})
+	for _, name := range []string{"entrypoint", "windows", "networks", "sitemaps"} {
+		if _, ok := userProperties[name]; !ok {
+			t.Errorf("Schema() is missing the user property %s, including inlined conditions", name)
+		}
+	}
+
+	timeout := properties["server"].(map[string]interface{})["properties"].(map[string]interface{})["idle_timeout"].(map[string]interface{})
+	if timeout["pattern"] != durationPattern {
+		t.Errorf("Schema() idle_timeout = %v, want a duration", timeout)
+	}
+}
+
+func TestSchemaIsPublished(t *testing.T) {
+	published, err := ioutil.ReadFile("../config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(published) != string(data)+"\n" {
+		t.Errorf("config.schema.json is outdated, run `make schema`")
+	}
+}
@@ -1,16 +1,120 @@
 package config
 
+import (
+	"net"
+	"regexp"
+	"text/template"
+	"time"
+)
+
 // Main is the root level of the config
 type Main struct {
-	Passthrough bool             `yaml:"passthrough"`
-	Users       map[string]*User `yaml:"users"`
+	Include        []string             `yaml:"include"`
+	Passthrough    bool                 `yaml:"passthrough"`
+	OpenHABVersion string               `yaml:"openhab_version"`
+	BasePath       string               `yaml:"base_path"`
+	TrustedProxies []string             `yaml:"trusted_proxies"`
+	RateLimits     RateLimits           `yaml:"rate_limits"`
+	Upstreams      map[string]*Upstream `yaml:"upstreams"`
+	HealthCheck    HealthCheck          `yaml:"health_check"`
+	Routes         map[string]string    `yaml:"routes"`
+	UpstreamAuth   *Credentials         `yaml:"upstream_auth"`
+	Headers        Headers              `yaml:"headers"`
+	WebSockets     WebSockets           `yaml:"websockets"`
+	Server         Server               `yaml:"server"`
+	Transport      Transport            `yaml:"transport"`
+	BodyLimits     map[string]int64     `yaml:"body_limits"`
+	ErrorPages     map[int]string       `yaml:"error_pages"`
+	Portal         *Portal              `yaml:"portal"`
+	Users          map[string]*User     `yaml:"users"`
+
+	// trustedProxies are parsed by Validate
+	trustedProxies []*net.IPNet
+}
+
+// OpenHABVersions lists the supported values of `openhab_version`
+var OpenHABVersions = []string{"2.5", "3", "4"}
+
+// DefaultUpstream is the name of the upstream given by `-target`
+const DefaultUpstream = "default"
+
+// Upstream is an openHAB instance requests can be routed to;
+// with several backends, the first healthy one in order is used
+type Upstream struct {
+	Target   string   `yaml:"target"`
+	Backends []string `yaml:"backends"`
+}
+
+// Targets of the upstream in order of preference
+func (u *Upstream) Targets() []string {
+	if u.Target == "" {
+		return u.Backends
+	}
+	return append([]string{u.Target}, u.Backends...)
+}
+
+// HealthCheck configures how backends are checked
+type HealthCheck struct {
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	EjectFor time.Duration `yaml:"eject_for"`
+}
+
+// Server configures the timeouts and limits of the router's listener
+type Server struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	RequestTimeout    time.Duration `yaml:"request_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+}
+
+// Transport configures the connections to openHAB
+type Transport struct {
+	DialTimeout           time.Duration `yaml:"dial_timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout"`
+	MaxIdleConns          int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost   int           `yaml:"max_idle_conns_per_host"`
+	FlushInterval         time.Duration `yaml:"flush_interval"`
+}
+
+// Portal is a page served by the router, which links everything a user can open
+type Portal struct {
+	Path      string `yaml:"path"`
+	Template  string `yaml:"template"`
+	LogoutURL string `yaml:"logout_url"`
+}
+
+// WebSockets limits proxied WebSocket connections; zero values disable a limit
+type WebSockets struct {
+	IdleTimeout           time.Duration `yaml:"idle_timeout"`
+	MaxConnections        int           `yaml:"max_connections"`
+	MaxConnectionsPerUser int           `yaml:"max_connections_per_user"`
 }
 
 // User configures each users access
 type User struct {
-	Entrypoint string           `yaml:"entrypoint"`
-	Sitemaps   Sitemap          `yaml:"sitemaps"`
-	Paths      map[string]*Path `yaml:"paths"`
+	Conditions        `yaml:",inline"`
+	Entrypoint        string           `yaml:"entrypoint"`
+	Sitemaps          Sitemap          `yaml:"sitemaps"`
+	Paths             map[string]*Path `yaml:"paths"`
+	Items             map[string]*Item `yaml:"items"`
+	DenyUnlistedItems bool             `yaml:"deny_unlisted_items"`
+	AdminAPI          bool             `yaml:"admin_api"`
+	HABPanel          *HABPanel        `yaml:"habpanel"`
+	MainUI            *MainUI          `yaml:"main_ui"`
+	RateLimit         *RateLimit       `yaml:"rate_limit"`
+	Upstream          string           `yaml:"upstream"`
+	UpstreamAuth      *Credentials     `yaml:"upstream_auth"`
+}
+
+// ItemAllowed reports whether the user may read and control the item
+func (u *User) ItemAllowed(name string) bool {
+	item, ok := u.Items[name]
+	if !ok || item == nil {
+		return !u.DenyUnlistedItems
+	}
+	return item.IsAllowed()
 }
 
 // UserName extends User by the name property
@@ -21,13 +125,90 @@ type UserName struct {
 
 // Sitemap defines defaults, allowed
 type Sitemap struct {
-	Default string   `yaml:"default"`
-	Allowed []string `yaml:"allowed"`
+	Default string                  `yaml:"default"`
+	Allowed []string                `yaml:"allowed"`
+	Rules   map[string]*SitemapRule `yaml:"rules"`
+	Hidden  WidgetFilter            `yaml:"hidden"`
+}
+
+// WidgetFilter hides widgets of allowed sitemaps
+type WidgetFilter struct {
+	Labels []string `yaml:"labels"`
+	Pages  []string `yaml:"pages"`
+}
+
+// HABPanel restricts the dashboards a user can see; without it, every dashboard is visible
+type HABPanel struct {
+	Dashboards []string `yaml:"dashboards"`
+}
+
+// DashboardAllowed reports whether the dashboard with the given id or name is visible
+func (h *HABPanel) DashboardAllowed(dashboard string) bool {
+	if h == nil {
+		return true
+	}
+	for _, allowed := range h.Dashboards {
+		if allowed == dashboard {
+			return true
+		}
+	}
+	return false
+}
+
+// MainUI restricts the pages and custom widgets of the openHAB 3/4 Main UI;
+// omitted lists do not restrict anything and `*` allows every component
+type MainUI struct {
+	Entrypoint string   `yaml:"entrypoint"`
+	Pages      []string `yaml:"pages"`
+	Widgets    []string `yaml:"widgets"`
+}
+
+// PageAllowed reports whether the user may open the page; the entrypoint is always allowed
+func (m *MainUI) PageAllowed(uid string) bool {
+	if m == nil || uid == m.Entrypoint {
+		return true
+	}
+	return componentListed(m.Pages, uid)
+}
+
+// WidgetAllowed reports whether the user may load the custom widget
+func (m *MainUI) WidgetAllowed(uid string) bool {
+	if m == nil {
+		return true
+	}
+	return componentListed(m.Widgets, uid)
+}
+
+func componentListed(allowed []string, uid string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == "*" || a == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// SitemapRule restricts access to an allowed sitemap
+type SitemapRule struct {
+	Conditions `yaml:",inline"`
 }
 
 // Path a user can or cannot access
 type Path struct {
-	Allowed bool `yaml:"allowed"`
+	Conditions `yaml:",inline"`
+	Allowed    bool `yaml:"allowed"`
+}
+
+// Conditions which have to be met for a user or rule to grant access
+type Conditions struct {
+	Windows  []*Window `yaml:"windows"`
+	Networks []string  `yaml:"networks"`
+
+	// networks are parsed by Validate
+	networks []*net.IPNet
 }
 
 // PathName extends path to get the actual path whic is the map key
@@ -35,3 +216,66 @@ type PathName struct {
 	Name string
 	*Path
 }
+
+// Item a user can or cannot send commands to
+type Item struct {
+	Allowed  *bool    `yaml:"allowed"`
+	Commands *Command `yaml:"commands"`
+}
+
+// IsAllowed reports whether the item is accessible; items are allowed unless denied explicitly
+func (i *Item) IsAllowed() bool {
+	return i.Allowed == nil || *i.Allowed
+}
+
+// Command restricts the values a user can send to an item
+type Command struct {
+	Allowed []string `yaml:"allowed"`
+	Min     *float64 `yaml:"min"`
+	Max     *float64 `yaml:"max"`
+	Unit    string   `yaml:"unit"`
+	Pattern string   `yaml:"pattern"`
+
+	// pattern is compiled by Validate
+	pattern *regexp.Regexp
+}
+
+// MatchesPattern reports whether the whole command matches `pattern`, as compiled by Validate
+func (c *Command) MatchesPattern(command string) bool {
+	return c.pattern != nil && c.pattern.MatchString(command)
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// RateLimits configures the token buckets requests are taken from
+type RateLimits struct {
+	Global *RateLimit            `yaml:"global"`
+	User   *RateLimit            `yaml:"user"`
+	Paths  map[string]*RateLimit `yaml:"paths"`
+}
+
+// RateLimit refills a token bucket with `rate` tokens per second up to `burst` tokens
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// Headers configures the headers of requests sent to openHAB
+type Headers struct {
+	Remove []string          `yaml:"remove"`
+	Add    map[string]string `yaml:"add"`
+	Sign   *Signature        `yaml:"sign"`
+
+	// templates of `add`, parsed by Validate
+	templates map[string]*template.Template
+}
+
+// Signature configures the header carrying the signed identity of the user
+type Signature struct {
+	Header     string `yaml:"header"`
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+	SecretEnv  string `yaml:"secret_env"`
+}
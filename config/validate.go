@@ -2,10 +2,107 @@ package config
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 )
 
 // Validate config struct with some basic assertions
 func Validate(config *Main) error {
+	if config.BasePath != "" && (!strings.HasPrefix(config.BasePath, "/") || strings.HasSuffix(config.BasePath, "/")) {
+		return fmt.Errorf("The field `base_path` has to start and must not end with a slash, e.g. '/openhab'")
+	}
+
+	if config.OpenHABVersion != "" && !contains(OpenHABVersions, config.OpenHABVersion) {
+		return fmt.Errorf("The field `openhab_version` has to be one of %s", strings.Join(OpenHABVersions, ", "))
+	}
+
+	trustedProxies, err := ParseNetworks(config.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("The field `trusted_proxies` is invalid: %s", err)
+	}
+	config.trustedProxies = trustedProxies
+
+	if err := validateRateLimit(config.RateLimits.Global); err != nil {
+		return fmt.Errorf("The field `rate_limits.global` is invalid: %s", err)
+	}
+
+	if err := validateRateLimit(config.RateLimits.User); err != nil {
+		return fmt.Errorf("The field `rate_limits.user` is invalid: %s", err)
+	}
+
+	for path, limit := range config.RateLimits.Paths {
+		if err := validateRateLimit(limit); err != nil {
+			return fmt.Errorf("The rate limit for path '%s' is invalid: %s", path, err)
+		}
+	}
+
+	for name, upstream := range config.Upstreams {
+		if name == DefaultUpstream {
+			return fmt.Errorf("The upstream name '%s' is reserved for the `-target` option", DefaultUpstream)
+		}
+		if upstream == nil || len(upstream.Targets()) == 0 {
+			return fmt.Errorf("The field `target` or `backends` is missing for upstream '%s'", name)
+		}
+		for _, target := range upstream.Targets() {
+			if err := validateTarget(target); err != nil {
+				return fmt.Errorf("The target '%s' of upstream '%s' is invalid: %s", target, name, err)
+			}
+		}
+	}
+
+	if config.HealthCheck.Interval < 0 || config.HealthCheck.Timeout < 0 || config.HealthCheck.EjectFor < 0 {
+		return fmt.Errorf("The durations of `health_check` must not be negative")
+	}
+
+	if config.WebSockets.IdleTimeout < 0 || config.WebSockets.MaxConnections < 0 || config.WebSockets.MaxConnectionsPerUser < 0 {
+		return fmt.Errorf("The values of `websockets` must not be negative")
+	}
+
+	if config.Server.ReadHeaderTimeout < 0 || config.Server.IdleTimeout < 0 || config.Server.RequestTimeout < 0 || config.Server.MaxHeaderBytes < 0 {
+		return fmt.Errorf("The values of `server` must not be negative")
+	}
+
+	if config.Transport.DialTimeout < 0 || config.Transport.ResponseHeaderTimeout < 0 || config.Transport.IdleConnTimeout < 0 ||
+		config.Transport.MaxIdleConns < 0 || config.Transport.MaxIdleConnsPerHost < 0 || config.Transport.FlushInterval < 0 {
+		return fmt.Errorf("The values of `transport` must not be negative")
+	}
+
+	for path, limit := range config.BodyLimits {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("The body limit path '%s' has to start with a slash", path)
+		}
+		if limit <= 0 {
+			return fmt.Errorf("The body limit of path '%s' has to be positive", path)
+		}
+	}
+
+	for status, file := range config.ErrorPages {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("The error page for status %d is invalid: only 4xx and 5xx statuses have error pages", status)
+		}
+		if file == "" {
+			return fmt.Errorf("The error page for status %d is missing its file", status)
+		}
+	}
+
+	if config.Portal != nil && config.Portal.Path != "" && !strings.HasPrefix(config.Portal.Path, "/") {
+		return fmt.Errorf("The field `portal.path` has to start with a slash, e.g. '/portal'")
+	}
+
+	if err := validateCredentials(config.UpstreamAuth); err != nil {
+		return fmt.Errorf("The field `upstream_auth` is invalid: %s", err)
+	}
+
+	if err := validateHeaders(&config.Headers); err != nil {
+		return fmt.Errorf("The field `headers` is invalid: %s", err)
+	}
+
+	for path, upstream := range config.Routes {
+		if !hasUpstream(config, upstream) {
+			return fmt.Errorf("The route '%s' refers to the unknown upstream '%s'", path, upstream)
+		}
+	}
+
 	for user, userData := range config.Users {
 		if len(userData.Entrypoint) == 0 {
 			return fmt.Errorf("The field `entrypoint` is missing for user '%s'", user)
@@ -18,6 +115,153 @@ func Validate(config *Main) error {
 		if len(userData.Sitemaps.Allowed) == 0 {
 			return fmt.Errorf("The field `sitemaps.allowed` is missing for user '%s'", user)
 		}
+
+		if userData.Upstream != "" && !hasUpstream(config, userData.Upstream) {
+			return fmt.Errorf("The user '%s' refers to the unknown upstream '%s'", user, userData.Upstream)
+		}
+
+		if err := validateCredentials(userData.UpstreamAuth); err != nil {
+			return fmt.Errorf("The field `upstream_auth` of user '%s' is invalid: %s", user, err)
+		}
+
+		if err := validateConditions(&userData.Conditions); err != nil {
+			return fmt.Errorf("The conditions of user '%s' are invalid: %s", user, err)
+		}
+
+		for sitemap, rule := range userData.Sitemaps.Rules {
+			if rule == nil {
+				continue
+			}
+			if err := validateConditions(&rule.Conditions); err != nil {
+				return fmt.Errorf("The rule for sitemap '%s' of user '%s' is invalid: %s", sitemap, user, err)
+			}
+		}
+
+		for path, pathData := range userData.Paths {
+			if pathData == nil {
+				continue
+			}
+			if err := validateConditions(&pathData.Conditions); err != nil {
+				return fmt.Errorf("The path '%s' of user '%s' is invalid: %s", path, user, err)
+			}
+		}
+
+		if err := validateRateLimit(userData.RateLimit); err != nil {
+			return fmt.Errorf("The field `rate_limit` of user '%s' is invalid: %s", user, err)
+		}
+
+		for item, itemData := range userData.Items {
+			if err := validateItem(itemData); err != nil {
+				return fmt.Errorf("The item '%s' of user '%s' is invalid: %s", item, user, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func hasUpstream(config *Main, name string) bool {
+	if name == DefaultUpstream {
+		return true
+	}
+	_, ok := config.Upstreams[name]
+	return ok
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validateTarget(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "unix" && (u.Path != "" || u.Opaque != "") {
+		return nil
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("expected an address like 'http://openhab:8080' or 'unix:///run/openhab.sock'")
+	}
+	return nil
+}
+
+func validateHeaders(headers *Headers) error {
+	templates, err := parseHeaderTemplates(headers.Add)
+	if err != nil {
+		return err
+	}
+	headers.templates = templates
+
+	if sign := headers.Sign; sign != nil && countSet(sign.Secret, sign.SecretFile, sign.SecretEnv) != 1 {
+		return fmt.Errorf("exactly one of `sign.secret`, `sign.secret_file` and `sign.secret_env` has to be set")
+	}
+
+	return nil
+}
+
+func validateConditions(conditions *Conditions) error {
+	for _, window := range conditions.Windows {
+		if window == nil {
+			continue
+		}
+		parsed, err := window.parse()
+		if err != nil {
+			return err
+		}
+		window.parsed = parsed
+	}
+
+	networks, err := ParseNetworks(conditions.Networks)
+	if err != nil {
+		return err
+	}
+	conditions.networks = networks
+
+	return nil
+}
+
+func validateItem(item *Item) error {
+	if item == nil || item.Commands == nil {
+		return nil
+	}
+
+	commands := item.Commands
+	if commands.Min != nil && commands.Max != nil && *commands.Min > *commands.Max {
+		return fmt.Errorf("`commands.min` must not be greater than `commands.max`")
+	}
+
+	if commands.Unit != "" && commands.Min == nil && commands.Max == nil {
+		return fmt.Errorf("`commands.unit` requires `commands.min` or `commands.max`")
+	}
+
+	if commands.Pattern != "" {
+		pattern, err := compilePattern(commands.Pattern)
+		if err != nil {
+			return fmt.Errorf("`commands.pattern` is not a valid regular expression: %s", err)
+		}
+		commands.pattern = pattern
+	}
+
+	return nil
+}
+
+func validateRateLimit(limit *RateLimit) error {
+	if limit == nil {
+		return nil
+	}
+
+	if limit.Rate <= 0 {
+		return fmt.Errorf("`rate` must be greater than 0")
+	}
+
+	if limit.Burst < 0 {
+		return fmt.Errorf("`burst` must not be negative")
 	}
 
 	return nil
@@ -65,6 +65,272 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "command range is inverted",
+			args: args{
+				config: &Main{
+					Passthrough: false,
+					Users: map[string]*User{
+						"test": &User{
+							Entrypoint: "test",
+							Sitemaps: Sitemap{
+								Default: "test",
+								Allowed: []string{"test"},
+							},
+							Items: map[string]*Item{
+								"Thermostat": {Commands: &Command{Min: float(25), Max: float(20)}},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "command unit without bounds",
+			args: args{
+				config: &Main{
+					Passthrough: false,
+					Users: map[string]*User{
+						"test": &User{
+							Entrypoint: "test",
+							Sitemaps: Sitemap{
+								Default: "test",
+								Allowed: []string{"test"},
+							},
+							Items: map[string]*Item{
+								"Thermostat": {Commands: &Command{Unit: "°C"}},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "command pattern is invalid",
+			args: args{
+				config: &Main{
+					Passthrough: false,
+					Users: map[string]*User{
+						"test": &User{
+							Entrypoint: "test",
+							Sitemaps: Sitemap{
+								Default: "test",
+								Allowed: []string{"test"},
+							},
+							Items: map[string]*Item{
+								"Scene": {Commands: &Command{Pattern: "[0-3"}},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "window has an unknown weekday",
+			args: args{
+				config: &Main{
+					Passthrough: false,
+					Users: map[string]*User{
+						"test": &User{
+							Entrypoint: "test",
+							Sitemaps: Sitemap{
+								Default: "test",
+								Allowed: []string{"test"},
+								Rules: map[string]*SitemapRule{
+									"alarm": {Conditions: Conditions{Windows: []*Window{{Weekdays: []string{"tuesday"}}}}},
+								},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "window has an unknown timezone",
+			args: args{
+				config: &Main{
+					Passthrough: false,
+					Users: map[string]*User{
+						"test": &User{
+							Conditions: Conditions{Windows: []*Window{{Timezone: "Mars/Olympus"}}},
+							Entrypoint: "test",
+							Sitemaps: Sitemap{
+								Default: "test",
+								Allowed: []string{"test"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "trusted proxy is not a network",
+			args: args{
+				config: &Main{
+					Passthrough:    true,
+					TrustedProxies: []string{"10.0.0.1"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "path network is not a network",
+			args: args{
+				config: &Main{
+					Passthrough: false,
+					Users: map[string]*User{
+						"test": &User{
+							Entrypoint: "test",
+							Sitemaps: Sitemap{
+								Default: "test",
+								Allowed: []string{"test"},
+							},
+							Paths: map[string]*Path{
+								"/paperui": {Allowed: true, Conditions: Conditions{Networks: []string{"home"}}},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rate limit without rate",
+			args: args{
+				config: &Main{
+					Passthrough: true,
+					RateLimits: RateLimits{
+						Paths: map[string]*RateLimit{"/rest/items": {Burst: 10}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "upstream uses the reserved name",
+			args: args{
+				config: &Main{
+					Passthrough: true,
+					Upstreams: map[string]*Upstream{
+						"default": {Target: "http://openhab:8080"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "upstream target is not an address",
+			args: args{
+				config: &Main{
+					Passthrough: true,
+					Upstreams: map[string]*Upstream{
+						"holiday": {Target: "holiday-home"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route refers to an unknown upstream",
+			args: args{
+				config: &Main{
+					Passthrough: true,
+					Routes:      map[string]string{"/habpanel": "panels"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "upstream credentials with token and username",
+			args: args{
+				config: &Main{
+					Passthrough:  true,
+					UpstreamAuth: &Credentials{Token: "token", Username: "kids"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "upstream credentials with two token sources",
+			args: args{
+				config: &Main{
+					Passthrough:  true,
+					UpstreamAuth: &Credentials{Token: "token", TokenEnv: "OPENHAB_TOKEN"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "header template is invalid",
+			args: args{
+				config: &Main{
+					Passthrough: true,
+					Headers: Headers{
+						Add: map[string]string{"X-Router-User": "{{.User"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "header signature without secret",
+			args: args{
+				config: &Main{
+					Passthrough: true,
+					Headers: Headers{
+						Sign: &Signature{Header: "X-Router-Signature"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "upstream on a unix socket",
+			args: args{
+				config: &Main{
+					Passthrough: true,
+					Upstreams: map[string]*Upstream{
+						"local": {Target: "unix:///run/openhab/openhab.sock"},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "openhab version is unknown",
+			args: args{
+				config: &Main{
+					Passthrough:    true,
+					OpenHABVersion: "2.4",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "openhab version is supported",
+			args: args{
+				config: &Main{
+					Passthrough:    true,
+					OpenHABVersion: "3",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "base path ends with a slash",
+			args: args{
+				config: &Main{
+					Passthrough: true,
+					BasePath:    "/openhab/",
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "valid config",
 			args: args{
@@ -93,3 +359,43 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func TestValidateParsesConditionsOnce(t *testing.T) {
+	window := &Window{Weekdays: []string{"tue"}, Timezone: "UTC"}
+	commands := &Command{Pattern: "ON|OFF"}
+	config := &Main{
+		TrustedProxies: []string{"10.0.0.0/8"},
+		Headers:        Headers{Add: map[string]string{"X-Router-User": "{{.User}}"}},
+		Users: map[string]*User{
+			"test": {
+				Entrypoint: "/start/index",
+				Sitemaps:   Sitemap{Default: "test", Allowed: []string{"test"}},
+				Conditions: Conditions{Windows: []*Window{window}, Networks: []string{"192.168.1.0/24"}},
+				Items:      map[string]*Item{"Light": {Commands: commands}},
+			},
+		},
+	}
+	if err := Validate(config); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	if window.parsed == nil {
+		t.Errorf("window has not been parsed")
+	}
+	if len(config.Users["test"].networks) != 1 {
+		t.Errorf("networks have not been parsed")
+	}
+	if len(config.trustedProxies) != 1 {
+		t.Errorf("trusted proxies have not been parsed")
+	}
+	if commands.pattern == nil || !commands.MatchesPattern("ON") || commands.MatchesPattern("ONE") {
+		t.Errorf("pattern has not been compiled")
+	}
+	if len(config.Headers.templates) != 1 {
+		t.Errorf("header templates have not been parsed")
+	}
+}
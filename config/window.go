@@ -0,0 +1,157 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	clockLayout = "15:04"
+	dateLayout  = "2006-01-02"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a period of time in which access is granted.
+//
+// All fields are optional; an empty window always matches.
+// When `to` is before `from`, the window spans midnight and belongs
+// to the weekday it starts on.
+type Window struct {
+	Weekdays []string `yaml:"weekdays"`
+	From     string   `yaml:"from"`
+	To       string   `yaml:"to"`
+	Timezone string   `yaml:"timezone"`
+	Start    string   `yaml:"start"`
+	End      string   `yaml:"end"`
+
+	// parsed is set by Validate
+	parsed *window
+}
+
+type window struct {
+	weekdays map[time.Weekday]bool
+	from     time.Duration
+	to       time.Duration
+	location *time.Location
+	start    time.Time
+	end      time.Time
+}
+
+// Contains reports whether the given time lies within the window, as parsed by Validate
+func (w *Window) Contains(t time.Time) bool {
+	parsed := w.parsed
+	if parsed == nil {
+		return false
+	}
+
+	t = t.In(parsed.location)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, parsed.location)
+	if !parsed.start.IsZero() && day.Before(parsed.start) {
+		return false
+	}
+	if !parsed.end.IsZero() && day.After(parsed.end) {
+		return false
+	}
+
+	clock := t.Sub(day)
+	if parsed.from <= parsed.to {
+		return parsed.onDay(t.Weekday()) && clock >= parsed.from && clock < parsed.to
+	}
+
+	// the window spans midnight
+	if clock >= parsed.from {
+		return parsed.onDay(t.Weekday())
+	}
+	return clock < parsed.to && parsed.onDay((t.Weekday()+6)%7)
+}
+
+func (w *window) onDay(day time.Weekday) bool {
+	return len(w.weekdays) == 0 || w.weekdays[day]
+}
+
+func (w *Window) parse() (*window, error) {
+	parsed := &window{
+		weekdays: map[time.Weekday]bool{},
+		to:       24 * time.Hour,
+		location: time.Local,
+	}
+
+	for _, name := range w.Weekdays {
+		day, ok := weekdays[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday '%s', use one of mon, tue, wed, thu, fri, sat, sun", name)
+		}
+		parsed.weekdays[day] = true
+	}
+
+	if w.Timezone != "" {
+		location, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone '%s'", w.Timezone)
+		}
+		parsed.location = location
+	}
+
+	var err error
+	if w.From != "" {
+		if parsed.from, err = parseClock(w.From); err != nil {
+			return nil, err
+		}
+	}
+	if w.To != "" {
+		if parsed.to, err = parseClock(w.To); err != nil {
+			return nil, err
+		}
+	}
+
+	if w.Start != "" {
+		if parsed.start, err = time.ParseInLocation(dateLayout, w.Start, parsed.location); err != nil {
+			return nil, fmt.Errorf("invalid start date '%s', expected YYYY-MM-DD", w.Start)
+		}
+	}
+	if w.End != "" {
+		if parsed.end, err = time.ParseInLocation(dateLayout, w.End, parsed.location); err != nil {
+			return nil, fmt.Errorf("invalid end date '%s', expected YYYY-MM-DD", w.End)
+		}
+	}
+	if !parsed.start.IsZero() && !parsed.end.IsZero() && parsed.end.Before(parsed.start) {
+		return nil, fmt.Errorf("end date '%s' is before start date '%s'", w.End, w.Start)
+	}
+
+	return parsed, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	clock, err := time.Parse(clockLayout, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day '%s', expected HH:MM", value)
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
+
+// InWindow reports whether the given time lies within any of the windows;
+// without windows, there is no restriction
+func (c *Conditions) InWindow(t time.Time) bool {
+	if len(c.Windows) == 0 {
+		return true
+	}
+	for _, w := range c.Windows {
+		if w != nil && w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindow_Contains(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	tests := []struct {
+		name    string
+		window  Window
+		time    time.Time
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:   "empty window always matches",
+			window: Window{},
+			time:   time.Date(2020, 3, 3, 3, 0, 0, 0, time.UTC),
+			want:   true,
+		},
+		{
+			name:   "within weekday and time of day",
+			window: Window{Weekdays: []string{"tue"}, From: "08:00", To: "12:00", Timezone: "UTC"},
+			time:   time.Date(2020, 3, 3, 9, 30, 0, 0, time.UTC),
+			want:   true,
+		},
+		{
+			name:   "end of time of day is exclusive",
+			window: Window{Weekdays: []string{"tue"}, From: "08:00", To: "12:00", Timezone: "UTC"},
+			time:   time.Date(2020, 3, 3, 12, 0, 0, 0, time.UTC),
+			want:   false,
+		},
+		{
+			name:   "wrong weekday",
+			window: Window{Weekdays: []string{"tue"}, From: "08:00", To: "12:00", Timezone: "UTC"},
+			time:   time.Date(2020, 3, 4, 9, 30, 0, 0, time.UTC),
+			want:   false,
+		},
+		{
+			name:   "time of day is evaluated in the timezone of the window",
+			window: Window{Weekdays: []string{"Tue"}, From: "08:00", To: "12:00", Timezone: "Europe/Berlin"},
+			time:   time.Date(2020, 3, 3, 11, 30, 0, 0, time.UTC),
+			want:   false,
+		},
+		{
+			name:   "window spanning midnight matches after midnight",
+			window: Window{Weekdays: []string{"fri"}, From: "22:00", To: "02:00", Timezone: "UTC"},
+			time:   time.Date(2020, 3, 7, 1, 0, 0, 0, time.UTC),
+			want:   true,
+		},
+		{
+			name:   "window spanning midnight belongs to the day it starts on",
+			window: Window{Weekdays: []string{"fri"}, From: "22:00", To: "02:00", Timezone: "UTC"},
+			time:   time.Date(2020, 3, 6, 1, 0, 0, 0, time.UTC),
+			want:   false,
+		},
+		{
+			name:   "before start date",
+			window: Window{Start: "2020-03-10", End: "2020-03-20", Timezone: "Europe/Berlin"},
+			time:   time.Date(2020, 3, 9, 23, 59, 0, 0, berlin),
+			want:   false,
+		},
+		{
+			name:   "end date is inclusive",
+			window: Window{Start: "2020-03-10", End: "2020-03-20", Timezone: "Europe/Berlin"},
+			time:   time.Date(2020, 3, 20, 23, 59, 0, 0, berlin),
+			want:   true,
+		},
+		{
+			name:    "invalid window never matches",
+			window:  Window{From: "8 o'clock"},
+			time:    time.Date(2020, 3, 3, 9, 0, 0, 0, time.UTC),
+			want:    false,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateConditions(&Conditions{Windows: []*Window{&tt.window}}); (err != nil) != tt.wantErr {
+				t.Fatalf("validateConditions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := tt.window.Contains(tt.time); got != tt.want {
+				t.Errorf("Contains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
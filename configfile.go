@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"gopkg.in/yaml.v2"
+)
+
+// secretKeys are the fields of the config, whose values are not logged
+var secretKeys = []string{"token", "password", "secret"}
+
+// collections are the sections of the config, whose entries are merged across files
+var collections = []string{"users", "upstreams", "routes", "body_limits", "error_pages", "rate_limits.paths"}
+
+// configFile is a part of the config as read from disk
+type configFile struct {
+	path string
+	data []byte
+}
+
+// readConfig reads the config from a file or a directory and the files they include;
+// it also returns the config for logging, see redactedConfig
+func readConfig(path string) (*config.Main, interface{}, error) {
+	files, err := readConfigFiles(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merged, err := mergeConfigFiles(files, interpolateDocument)
+	if err != nil {
+		return nil, nil, err
+	}
+	conf, err := decodeMain(merged, configFormats(files))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw, err := mergeConfigFiles(files, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conf, redactedConfig(raw), nil
+}
+
+// parseConfig replaces the placeholders of a single config file and decodes it
+func parseConfig(data []byte) (*config.Main, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("please ensure it is valid YAML: %s", err)
+	}
+	interpolated, err := interpolateDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMain(interpolated, "YAML")
+}
+
+// decodeMain converts the document into the types of the config; format names
+// the formats of the files it was read from for errors
+func decodeMain(doc yaml.MapSlice, format string) (*config.Main, error) {
+	data, err := yaml.Marshal(numericKeys(doc, "error_pages"))
+	if err != nil {
+		return nil, err
+	}
+	conf := &config.Main{}
+	if err := yaml.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("please ensure it is valid %s: %s", format, err)
+	}
+	return conf, nil
+}
+
+// numericKeys converts the keys of the section to integers, as the keys of
+// JSON and TOML are always strings, e.g. the statuses of `error_pages`
+func numericKeys(doc yaml.MapSlice, key string) yaml.MapSlice {
+	section, ok := lookup(doc, key)
+	mapping, isMapping := section.(yaml.MapSlice)
+	if !ok || !isMapping {
+		return doc
+	}
+	converted := make(yaml.MapSlice, len(mapping))
+	for i, item := range mapping {
+		converted[i] = item
+		if name, ok := item.Key.(string); ok {
+			if number, err := strconv.Atoi(name); err == nil {
+				converted[i].Key = number
+			}
+		}
+	}
+	result := make(yaml.MapSlice, len(doc))
+	for i, item := range doc {
+		result[i] = item
+		if fmt.Sprint(item.Key) == key {
+			result[i].Value = converted
+		}
+	}
+	return result
+}
+
+// configFormat names the format of a config file by its extension
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "JSON"
+	case ".toml":
+		return "TOML"
+	default:
+		return "YAML"
+	}
+}
+
+// configFormats names the formats of the files, e.g. `YAML and JSON`
+func configFormats(files []configFile) string {
+	var formats []string
+	for _, file := range files {
+		if format := configFormat(file.path); !contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+	return strings.Join(formats, " and ")
+}
+
+// interpolateDocument replaces the placeholders in the values of a parsed file,
+// see config.Interpolate; errors name the key of the value
+func interpolateDocument(doc yaml.MapSlice) (yaml.MapSlice, error) {
+	var errs []string
+	interpolated := interpolateValue(doc, nil, &errs)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to interpolate config: %s", strings.Join(errs, "; "))
+	}
+	return interpolated.(yaml.MapSlice), nil
+}
+
+func interpolateValue(value interface{}, path []string, errs *[]string) interface{} {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		result := make(yaml.MapSlice, len(v))
+		for i, item := range v {
+			key := append(append([]string{}, path...), fmt.Sprint(item.Key))
+			result[i] = yaml.MapItem{Key: item.Key, Value: interpolateValue(item.Value, key, errs)}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = interpolateValue(item, append(append([]string{}, path...), strconv.Itoa(i)), errs)
+		}
+		return result
+	case string:
+		if !strings.Contains(v, "${") {
+			return v
+		}
+		interpolated, err := config.Interpolate(v)
+		if err != nil {
+			*errs = append(*errs, fmt.Sprintf("'%s': %s", strings.Join(path, "."), err))
+			return v
+		}
+		return scalarValue(interpolated)
+	default:
+		return v
+	}
+}
+
+// scalarValue types an interpolated value, so placeholders can be used for numbers
+// and booleans like `rate: ${RATE:-5}`; only values, which are written the same
+// way again, are converted, so `0123` remains a string
+func scalarValue(value string) interface{} {
+	if b, err := strconv.ParseBool(value); err == nil && strconv.FormatBool(b) == value {
+		return b
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil && strconv.FormatInt(i, 10) == value {
+		return i
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil && strconv.FormatFloat(f, 'f', -1, 64) == value {
+		return f
+	}
+	return value
+}
+
+// readConfigFiles lists the files of the config in the order they are merged:
+// a file before the files it includes, the files of a directory sorted by name
+func readConfigFiles(path string) ([]configFile, error) {
+	var files []configFile
+	if err := collectConfigFiles(path, map[string]bool{}, &files); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("the directory '%s' does not contain any config files", path)
+	}
+	return files, nil
+}
+
+func collectConfigFiles(path string, seen map[string]bool, files *[]configFile) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		var matches []string
+		for _, pattern := range []string{"*.yaml", "*.yml", "*.json", "*.toml"} {
+			found, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return err
+			}
+			matches = append(matches, found...)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if err := collectConfigFiles(match, seen, files); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if seen[abs] {
+		return fmt.Errorf("the config file '%s' is included more than once", path)
+	}
+	seen[abs] = true
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	*files = append(*files, configFile{path: path, data: data})
+
+	doc, err := decodeConfigFile(path, data)
+	if err == nil {
+		doc, err = interpolateDocument(doc)
+	}
+	if err != nil {
+		return fmt.Errorf("'%s': %s", path, err)
+	}
+	var includes []string
+	if value, ok := lookup(doc, "include"); ok {
+		data, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(data, &includes); err != nil {
+			return fmt.Errorf("'%s': the include must be a list of paths", path)
+		}
+	}
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		matches, err := filepath.Glob(include)
+		if err != nil {
+			return fmt.Errorf("'%s': the include '%s' is invalid: %s", path, include, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("'%s': the include '%s' does not match any file", path, include)
+		}
+		for _, match := range matches {
+			if err := collectConfigFiles(match, seen, files); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeConfigFiles combines the files into one document. Sections are merged,
+// while a setting or an entry of a collection like a user must only be defined once.
+func mergeConfigFiles(files []configFile, transform func(yaml.MapSlice) (yaml.MapSlice, error)) (yaml.MapSlice, error) {
+	merged := yaml.MapSlice{}
+	origins := map[string]string{}
+	for _, file := range files {
+		doc, err := decodeConfigFile(file.path, file.data)
+		if err == nil && transform != nil {
+			doc, err = transform(doc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %s", file.path, err)
+		}
+		if merged, err = mergeMapping(merged, remove(remove(doc, "include"), "$schema"), nil, file.path, origins); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// decodeConfigFile reads a file of the config in the format given by its extension:
+// JSON for .json, TOML for .toml and YAML otherwise
+func decodeConfigFile(path string, data []byte) (yaml.MapSlice, error) {
+	var doc yaml.MapSlice
+	var err error
+	switch configFormat(path) {
+	case "JSON":
+		doc, err = decodeJSON(data)
+	case "TOML":
+		doc, err = decodeTOML(data)
+	default:
+		err = yaml.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("please ensure it is valid %s: %s", configFormat(path), err)
+	}
+	return doc, nil
+}
+
+// decodeJSON reads a JSON object keeping the order of its keys
+func decodeJSON(data []byte) (yaml.MapSlice, error) {
+	// an empty file is an empty config like in YAML
+	if len(bytes.TrimSpace(data)) == 0 {
+		return yaml.MapSlice{}, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeJSONValue(decoder)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the top-level object")
+	}
+	doc, ok := value.(yaml.MapSlice)
+	if !ok {
+		return nil, fmt.Errorf("the top-level value must be an object")
+	}
+	return doc, nil
+}
+
+func decodeJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '[' {
+			values := []interface{}{}
+			for decoder.More() {
+				value, err := decodeJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+			_, err := decoder.Token()
+			return values, err
+		}
+		doc := yaml.MapSlice{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := lookup(doc, key.(string)); ok {
+				return nil, fmt.Errorf("the key '%s' is defined more than once", key)
+			}
+			value, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			doc = append(doc, yaml.MapItem{Key: key, Value: value})
+		}
+		_, err := decoder.Token()
+		return doc, err
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	default:
+		return t, nil
+	}
+}
+
+func mergeMapping(dst yaml.MapSlice, src yaml.MapSlice, prefix []string, file string, origins map[string]string) (yaml.MapSlice, error) {
+	for _, item := range src {
+		path := append(append([]string{}, prefix...), fmt.Sprint(item.Key))
+		i := -1
+		for j := range dst {
+			if fmt.Sprint(dst[j].Key) == path[len(path)-1] {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			dst = append(dst, item)
+			origins[strings.Join(path, "\x00")] = file
+			continue
+		}
+
+		existing, ok := dst[i].Value.(yaml.MapSlice)
+		section, isSection := item.Value.(yaml.MapSlice)
+		if ok && isSection && !contains(collections, strings.Join(prefix, ".")) {
+			merged, err := mergeMapping(existing, section, path, file, origins)
+			if err != nil {
+				return nil, err
+			}
+			dst[i].Value = merged
+			continue
+		}
+		return nil, fmt.Errorf("'%s' is defined in both '%s' and '%s'", strings.Join(path, "."), origin(origins, path), file)
+	}
+	return dst, nil
+}
+
+// origin finds the file, which defined the path or the section containing it
+func origin(origins map[string]string, path []string) string {
+	for i := len(path); i > 0; i-- {
+		if file, ok := origins[strings.Join(path[:i], "\x00")]; ok {
+			return file
+		}
+	}
+	return ""
+}
+
+// redactedConfig is the config for logging: placeholders are kept
+// instead of their values and secrets written into the files are hidden
+func redactedConfig(doc yaml.MapSlice) interface{} {
+	return jsonValue(redact(doc))
+}
+
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		result := make(yaml.MapSlice, len(v))
+		for i, item := range v {
+			result[i] = yaml.MapItem{Key: item.Key, Value: redact(item.Value)}
+			if s, ok := item.Value.(string); ok && s != "" && !strings.Contains(s, "${") && contains(secretKeys, fmt.Sprint(item.Key)) {
+				result[i].Value = "REDACTED"
+			}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = redact(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// printSchema writes the JSON Schema of the config for editors to stdout
+func printSchema() {
+	data, err := json.MarshalIndent(config.Schema(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseConfig(t *testing.T) {
+	os.Setenv("OPENHAB_AUTH_ROUTER_TEST_TOKEN", "env-token")
+	defer os.Unsetenv("OPENHAB_AUTH_ROUTER_TEST_TOKEN")
+
+	conf, err := parseConfig([]byte("upstream_auth:\n  token: ${OPENHAB_AUTH_ROUTER_TEST_TOKEN}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "env-token", conf.UpstreamAuth.Token)
+
+	_, err = parseConfig([]byte("upstream_auth:\n  token: ${OPENHAB_AUTH_ROUTER_TEST_MISSING}\n"))
+	assert.EqualError(t, err, "failed to interpolate config: 'upstream_auth.token': environment variable 'OPENHAB_AUTH_ROUTER_TEST_MISSING' is not set")
+
+	_, err = parseConfig([]byte("users: ["))
+	assert.Error(t, err)
+}
+
+func TestInterpolatedValuesAreNotParsed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	value := "abc #def: \"quoted\"\nsecond: line"
+	os.Setenv("OPENHAB_AUTH_ROUTER_TEST_PASSWORD", value)
+	defer os.Unsetenv("OPENHAB_AUTH_ROUTER_TEST_PASSWORD")
+	os.Setenv("OPENHAB_AUTH_ROUTER_TEST_RATE", "2.5")
+	defer os.Unsetenv("OPENHAB_AUTH_ROUTER_TEST_RATE")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "secret.pem"), []byte("-----BEGIN KEY-----\nabc: def\n-----END KEY-----\n"), 0600))
+
+	files := map[string]string{
+		"config.yaml": "upstream_auth:\n  username: ${OPENHAB_AUTH_ROUTER_TEST_PASSWORD}\n  password: ${file:" + filepath.Join(dir, "secret.pem") + "}\n" +
+			"rate_limits:\n  global:\n    rate: ${OPENHAB_AUTH_ROUTER_TEST_RATE}\n    burst: ${OPENHAB_AUTH_ROUTER_TEST_MISSING:-10}\n",
+		"config.json": `{"upstream_auth": {"username": "${OPENHAB_AUTH_ROUTER_TEST_PASSWORD}", "password": "${file:` + filepath.Join(dir, "secret.pem") + `}"},
+			"rate_limits": {"global": {"rate": "${OPENHAB_AUTH_ROUTER_TEST_RATE}", "burst": "${OPENHAB_AUTH_ROUTER_TEST_MISSING:-10}"}}}`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+			conf, _, err := readConfig(path)
+			assert.NoError(t, err)
+			assert.Equal(t, value, conf.UpstreamAuth.Username)
+			assert.Equal(t, "-----BEGIN KEY-----\nabc: def\n-----END KEY-----", conf.UpstreamAuth.Password)
+			assert.Equal(t, 2.5, conf.RateLimits.Global.Rate)
+			assert.Equal(t, 10, conf.RateLimits.Global.Burst)
+		})
+	}
+}
+
+func Test_scalarValue(t *testing.T) {
+	assert.Equal(t, true, scalarValue("true"))
+	assert.Equal(t, int64(5), scalarValue("5"))
+	assert.Equal(t, 1.5, scalarValue("1.5"))
+	assert.Equal(t, "0123", scalarValue("0123"))
+	assert.Equal(t, "1e3", scalarValue("1e3"))
+	assert.Equal(t, "TRUE", scalarValue("TRUE"))
+	assert.Equal(t, "abc", scalarValue("abc"))
+}
+
+func TestRedactedConfig(t *testing.T) {
+	data := []byte(`upstream_auth:
+  username: openhab
+  password: ${file:/run/secrets/openhab}
+headers:
+  sign:
+    secret: inline-secret
+users:
+  john:
+    upstream_auth:
+      token: inline-token
+      token_file: /run/secrets/john
+`)
+	var doc yaml.MapSlice
+	assert.NoError(t, yaml.Unmarshal(data, &doc))
+	assert.Equal(t, map[string]interface{}{
+		"upstream_auth": map[string]interface{}{
+			"username": "openhab",
+			"password": "${file:/run/secrets/openhab}",
+		},
+		"headers": map[string]interface{}{
+			"sign": map[string]interface{}{"secret": "REDACTED"},
+		},
+		"users": map[string]interface{}{
+			"john": map[string]interface{}{
+				"upstream_auth": map[string]interface{}{
+					"token":      "REDACTED",
+					"token_file": "/run/secrets/john",
+				},
+			},
+		},
+	}, redactedConfig(doc))
+}
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		config  string
+		users   []string
+		wantErr string
+	}{
+		{
+			name: "includes",
+			files: map[string]string{
+				"config.yaml":       "include:\n  - users/*.yaml\npassthrough: false\nserver:\n  idle_timeout: 1m\n",
+				"users/john.yaml":   "users:\n  john:\n    entrypoint: /start/index\n",
+				"users/jane.yaml":   "users:\n  jane:\n    entrypoint: /start/index\nserver:\n  request_timeout: 30s\n",
+				"users/notes.txt":   "not a config file",
+				"unused/other.yaml": "users:\n  other: {}\n",
+			},
+			config: "config.yaml",
+			users:  []string{"jane", "john"},
+		},
+		{
+			name: "directory",
+			files: map[string]string{
+				"conf.d/10-main.yaml": "passthrough: false\n",
+				"conf.d/20-john.yml":  "users:\n  john:\n    entrypoint: /start/index\n",
+			},
+			config: "conf.d",
+			users:  []string{"john"},
+		},
+		{
+			name: "formats",
+			files: map[string]string{
+				"conf.d/10-main.json": `{"$schema": "../config.schema.json", "passthrough": false, "include": ["../users.toml"], "server": {"idle_timeout": "1m"}}`,
+				"conf.d/20-john.toml": "[users.john]\nentrypoint = \"/start/index\"\n",
+				"users.toml":          "[users.jane.sitemaps]\ndefault = \"jane\"\n",
+			},
+			config: "conf.d",
+			users:  []string{"jane", "john"},
+		},
+		{
+			name: "duplicate user",
+			files: map[string]string{
+				"conf.d/john.yaml":  "users:\n  john:\n    entrypoint: /start/index\n",
+				"conf.d/other.yaml": "users:\n  john:\n    sitemaps:\n      default: john\n",
+			},
+			config:  "conf.d",
+			wantErr: "'users.john' is defined in both",
+		},
+		{
+			name: "duplicate setting",
+			files: map[string]string{
+				"config.yaml": "include: [server.yaml]\nserver:\n  idle_timeout: 1m\n",
+				"server.yaml": "server:\n  idle_timeout: 2m\n",
+			},
+			config:  "config.yaml",
+			wantErr: "'server.idle_timeout' is defined in both",
+		},
+		{
+			name: "duplicate path rate limit",
+			files: map[string]string{
+				"config.yaml": "include: [limits.yaml]\nrate_limits:\n  paths:\n    /rest/:\n      rate: 1\n",
+				"limits.yaml": "rate_limits:\n  paths:\n    /rest/:\n      burst: 2\n",
+			},
+			config:  "config.yaml",
+			wantErr: "'rate_limits.paths./rest/' is defined in both",
+		},
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"config.yaml": "include: [other.yaml]\n",
+				"other.yaml":  "include: [config.yaml]\n",
+			},
+			config:  "config.yaml",
+			wantErr: "is included more than once",
+		},
+		{
+			name: "missing include",
+			files: map[string]string{
+				"config.yaml": "include: [missing.yaml]\n",
+			},
+			config:  "config.yaml",
+			wantErr: "does not match any file",
+		},
+		{
+			name:    "empty directory",
+			files:   map[string]string{"conf.d/README": ""},
+			config:  "conf.d",
+			wantErr: "does not contain any config files",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "router")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+			for name, content := range tt.files {
+				path := filepath.Join(dir, name)
+				assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+				assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+			}
+
+			conf, _, err := readConfig(filepath.Join(dir, tt.config))
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), tt.wantErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			var users []string
+			for name := range conf.Users {
+				users = append(users, name)
+			}
+			assert.ElementsMatch(t, tt.users, users)
+			assert.Empty(t, conf.Include)
+		})
+	}
+}
+
+func TestReadConfigErrorPages(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		config  string
+		wantErr string
+	}{
+		{
+			name:   "json",
+			files:  map[string]string{"config.json": `{"passthrough": true, "error_pages": {"404": "404.html"}}`},
+			config: "config.json",
+		},
+		{
+			name:   "toml",
+			files:  map[string]string{"config.toml": "passthrough = true\n[error_pages]\n404 = \"404.html\"\n"},
+			config: "config.toml",
+		},
+		{
+			name: "yaml including json",
+			files: map[string]string{
+				"config.yaml": "passthrough: true\ninclude: [pages.json]\n",
+				"pages.json":  `{"error_pages": {"404": "404.html"}}`,
+			},
+			config: "config.yaml",
+		},
+		{
+			name:    "status is not a number",
+			files:   map[string]string{"config.json": `{"error_pages": {"missing": "404.html"}}`},
+			config:  "config.json",
+			wantErr: "please ensure it is valid JSON",
+		},
+		{
+			name: "formats of included files",
+			files: map[string]string{
+				"config.yaml": "include: [pages.toml]\n",
+				"pages.toml":  "[error_pages]\nmissing = \"404.html\"\n",
+			},
+			config:  "config.yaml",
+			wantErr: "please ensure it is valid YAML and TOML",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "router")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+			for name, content := range tt.files {
+				assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+			}
+
+			conf, _, err := readConfig(filepath.Join(dir, tt.config))
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), tt.wantErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, map[int]string{404: "404.html"}, conf.ErrorPages)
+		})
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	doc, err := decodeJSON([]byte(`{"users": {"john": {"entrypoint": "/start/index"}}, "body_limits": {"/rest/": 1024}, "ratio": 1.5, "passthrough": true, "portal": null}`))
+	assert.NoError(t, err)
+	assert.Equal(t, yaml.MapSlice{
+		{Key: "users", Value: yaml.MapSlice{{Key: "john", Value: yaml.MapSlice{{Key: "entrypoint", Value: "/start/index"}}}}},
+		{Key: "body_limits", Value: yaml.MapSlice{{Key: "/rest/", Value: int64(1024)}}},
+		{Key: "ratio", Value: 1.5},
+		{Key: "passthrough", Value: true},
+		{Key: "portal", Value: nil},
+	}, doc)
+
+	doc, err = decodeJSON([]byte(" \n"))
+	assert.NoError(t, err)
+	assert.Empty(t, doc)
+
+	_, err = decodeJSON([]byte(`{"users": {}, "users": {}}`))
+	assert.EqualError(t, err, "the key 'users' is defined more than once")
+
+	_, err = decodeJSON([]byte(`["users"]`))
+	assert.EqualError(t, err, "the top-level value must be an object")
+
+	_, err = decodeJSON([]byte(`{"users": {}} {}`))
+	assert.EqualError(t, err, "unexpected data after the top-level object")
+
+	_, err = decodeJSON([]byte(`{"users": `))
+	assert.Error(t, err)
+}
+
+func TestReadConfigMergesSections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.yaml"), []byte("server:\n  idle_timeout: 1m\nupstream_auth:\n  token: secret\n"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.yaml"), []byte("server:\n  request_timeout: 30s\n"), 0600))
+
+	conf, redacted, err := readConfig(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "1m0s", conf.Server.IdleTimeout.String())
+	assert.Equal(t, "30s", conf.Server.RequestTimeout.String())
+	assert.Equal(t, "secret", conf.UpstreamAuth.Token)
+	assert.Equal(t, map[string]interface{}{
+		"server":        map[string]interface{}{"idle_timeout": "1m", "request_timeout": "30s"},
+		"upstream_auth": map[string]interface{}{"token": "REDACTED"},
+	}, redacted)
+}
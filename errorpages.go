@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultErrorPage is rendered for HTML clients, unless a file overrides the status
+const defaultErrorPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{.Status}} {{.Title}}</title>
+  <style>
+    body { font-family: sans-serif; margin: 3em auto; max-width: 36em; padding: 0 1em; color: #333; }
+    h1 { font-weight: normal; }
+    a { color: #e64a19; }
+  </style>
+</head>
+<body>
+  <h1>{{.Title}}</h1>
+  {{if .Detail}}<p>{{.Detail}}</p>{{end}}
+  {{if .Sitemaps}}<p>You can open these sitemaps instead:</p>
+  <ul>
+    {{range .Sitemaps}}<li><a href="{{$.BasePath}}/basicui/app?sitemap={{.}}">{{.}}</a></li>
+    {{end}}
+  </ul>{{end}}
+  {{if .Entrypoint}}<p><a href="{{.BasePath}}{{.Entrypoint}}">Back to the start page</a></p>{{end}}
+</body>
+</html>
+`
+
+// ErrorData is available to the templates of error pages
+type ErrorData struct {
+	Status     int
+	Title      string
+	Detail     string
+	Instance   string
+	User       string
+	Entrypoint string
+	BasePath   string
+	Sitemaps   []string
+}
+
+// problem is the JSON error response as defined by RFC 7807
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// ErrorPages renders error responses in the format the client accepts
+type ErrorPages struct {
+	fallback  *template.Template
+	overrides map[int]*template.Template
+}
+
+// defaultErrorPages renders the default error page for every status
+func defaultErrorPages() *ErrorPages {
+	return &ErrorPages{
+		fallback:  template.Must(template.New("error").Parse(defaultErrorPage)),
+		overrides: map[int]*template.Template{},
+	}
+}
+
+// NewErrorPages loads the HTML templates overriding the default error page per status
+func NewErrorPages(files map[int]string) (*ErrorPages, error) {
+	pages := defaultErrorPages()
+	for status, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read error page for status %d: %s", status, err)
+		}
+		tmpl, err := template.New(file).Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse error page for status %d: %s", status, err)
+		}
+		pages.overrides[status] = tmpl
+	}
+	return pages, nil
+}
+
+// errorFormat picks the format of the error response by the `Accept` header
+// and, without one, by the `Content-Type` of the request
+func errorFormat(req *http.Request) string {
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		accept = req.Header.Get("Content-Type")
+	}
+	switch {
+	case strings.Contains(accept, "application/json"), strings.Contains(accept, "application/problem+json"):
+		return "json"
+	case strings.Contains(accept, "text/html"):
+		return "html"
+	default:
+		return "text"
+	}
+}
+
+// writeProblem responds with a JSON problem document
+func writeProblem(w http.ResponseWriter, req *http.Request, status int, detail string) error {
+	w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: req.URL.RequestURI(),
+	})
+}
+
+// Write responds with the error; data may be nil and is completed by the status and request
+func (p *ErrorPages) Write(w http.ResponseWriter, req *http.Request, status int, detail string, data *ErrorData) {
+	if data == nil {
+		data = &ErrorData{}
+	}
+	data.Status = status
+	data.Title = http.StatusText(status)
+	data.Detail = detail
+	data.Instance = req.URL.RequestURI()
+
+	var err error
+	switch errorFormat(req) {
+	case "json":
+		err = writeProblem(w, req, status, detail)
+	case "html":
+		tmpl, ok := p.overrides[status]
+		if !ok {
+			tmpl = p.fallback
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		err = tmpl.Execute(w, data)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		_, err = w.Write([]byte(detail))
+	}
+	if err != nil {
+		log.Error().Err(err).Int("status", status).Msg("failed to write error response")
+	}
+}
+
+// userErrorData links the pages the user may open from an error page
+func userErrorData(name string, user *config.User, conf *config.Main) *ErrorData {
+	data := &ErrorData{User: name, Entrypoint: user.Entrypoint, BasePath: conf.BasePath}
+
+	seen := map[string]bool{}
+	for _, sitemap := range append([]string{user.Sitemaps.Default}, user.Sitemaps.Allowed...) {
+		if sitemap == "" || sitemap == "*" || seen[sitemap] {
+			continue
+		}
+		seen[sitemap] = true
+		data.Sitemaps = append(data.Sitemaps, sitemap)
+	}
+	return data
+}
+
+// forbid answers with HTTP 403 and links the pages the user may open instead
+func forbid(w http.ResponseWriter, req *http.Request, pages *ErrorPages, conf *config.Main, user string, detail string) {
+	var data *ErrorData
+	if userConfig, ok := conf.Users[user]; ok {
+		data = userErrorData(user, userConfig, conf)
+	}
+	pages.Write(w, req, http.StatusForbidden, detail, data)
+}
+
+// forbiddenSitemap finds Basic UI page loads of a sitemap the user may not open;
+// other requests for such sitemaps are sent to the default sitemap by ruleDirector
+func forbiddenSitemap(req *http.Request, user *config.User, conf *config.Main) (string, bool) {
+	if !strings.HasPrefix(req.URL.Path, "/basicui/app") || errorFormat(req) != "html" {
+		return "", false
+	}
+	sitemap := req.URL.Query().Get("sitemap")
+	if sitemap == "" || sitemap == user.Sitemaps.Default || sitemapAllowed(user, sitemap, req, conf) {
+		return "", false
+	}
+	return sitemap, true
+}
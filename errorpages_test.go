@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorsAreWrittenInAcceptedFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{
+			name:        "json",
+			accept:      "application/json",
+			contentType: "application/problem+json; charset=utf-8",
+			body:        `{"type":"about:blank","title":"Bad Request","status":400,"detail":"missing header","instance":"/rest/items"}` + "\n",
+		},
+		{
+			name:        "plain text",
+			contentType: "text/plain; charset=utf-8",
+			body:        "missing header",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := makeGETRequest("/rest/items", "")
+			req.Header.Set("Accept", tt.accept)
+			rr := httptest.NewRecorder()
+			failRequest(rr, req, defaultErrorPages(), "missing header")
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.Equal(t, tt.contentType, rr.Header().Get("Content-Type"))
+			assert.Equal(t, tt.body, rr.Body.String())
+		})
+	}
+}
+
+func TestForbiddenSitemapPageListsAllowedSitemaps(t *testing.T) {
+	conf := &config.Main{
+		BasePath: "/openhab",
+		Users: map[string]*config.User{
+			"demo": {
+				Entrypoint: "/basicui/app",
+				Sitemaps:   config.Sitemap{Default: "demo", Allowed: []string{"demo", "widgetoverview"}},
+			},
+		},
+	}
+	req := makeGETRequest("/basicui/app?sitemap=admin", "demo")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	rr := httptest.NewRecorder()
+	mainHandler(rr, req, defaultErrorPages(), conf, nil)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "You don&#39;t have access to the sitemap &#39;admin&#39;.")
+	assert.Contains(t, rr.Body.String(), `<a href="/openhab/basicui/app?sitemap=demo">demo</a>`)
+	assert.Contains(t, rr.Body.String(), `<a href="/openhab/basicui/app?sitemap=widgetoverview">widgetoverview</a>`)
+}
+
+func TestErrorPagesCanBeOverridden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "403.html")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`<p>{{.User}} may not open {{.Instance}}</p>`), 0644))
+
+	pages, err := NewErrorPages(map[int]string{http.StatusForbidden: file})
+	assert.NoError(t, err)
+
+	req := makeGETRequest("/paperui/index.html", "demo")
+	req.Header.Set("Accept", "text/html")
+	rr := httptest.NewRecorder()
+	pages.Write(rr, req, http.StatusForbidden, "", &ErrorData{User: "demo"})
+	assert.Equal(t, `<p>demo may not open /paperui/index.html</p>`, rr.Body.String())
+
+	// other statuses keep the default page
+	rr = httptest.NewRecorder()
+	pages.Write(rr, req, http.StatusTooManyRequests, "", nil)
+	assert.Contains(t, rr.Body.String(), "<h1>Too Many Requests</h1>")
+
+	// the router renders the pages of its config
+	_, mux, closeRemote := testRouter(t, &config.Main{ErrorPages: map[int]string{http.StatusForbidden: file}}, func(w http.ResponseWriter, r *http.Request) {})
+	defer closeRemote()
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, `<p> may not open /paperui/index.html</p>`, rr.Body.String())
+
+	_, err = NewErrorPages(map[int]string{http.StatusForbidden: filepath.Join(dir, "missing.html")})
+	assert.Error(t, err)
+}
@@ -1,11 +1,13 @@
 module github.com/hendrikmaus/openhab-auth-router
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rs/zerolog v1.18.0
 	github.com/stretchr/testify v1.2.2
 	gopkg.in/yaml.v2 v2.2.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 go 1.13
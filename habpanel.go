@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+)
+
+// habpanelAccessAllowed keeps users with restricted dashboards from saving
+// the panel configuration, as it would replace the dashboards hidden from them
+func habpanelAccessAllowed(req *http.Request, user *config.User) bool {
+	if user.HABPanel == nil || strings.TrimSuffix(req.URL.Path, "/") != habpanelConfigPath {
+		return true
+	}
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+// filterHABPanel removes the dashboards the user may not see from the HABPanel configuration.
+//
+// HABPanel keeps all panel configurations as one JSON encoded string in
+// `panelsRegistry`, each of them listing its `dashboards`.
+func (r *Router) filterHABPanel(resp *http.Response) error {
+	conf := r.CurrentConfig()
+	user, ok := conf.Users[requestUser(resp.Request)]
+	if conf.Passthrough || !ok || user.HABPanel == nil || resp.StatusCode != http.StatusOK ||
+		strings.TrimSuffix(requestURL(resp.Request).Path, "/") != habpanelConfigPath ||
+		!strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+
+	var settings map[string]interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&settings); err != nil {
+		return fmt.Errorf("failed to decode habpanel config: %s", err)
+	}
+	_ = resp.Body.Close()
+
+	if registry, ok := settings["panelsRegistry"].(string); ok {
+		filtered, err := filterPanelsRegistry(registry, user.HABPanel)
+		if err != nil {
+			return err
+		}
+		settings["panelsRegistry"] = filtered
+	}
+	// the editor would save the filtered registry
+	settings["lockEditing"] = true
+
+	body, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	return nil
+}
+
+func filterPanelsRegistry(registry string, habpanel *config.HABPanel) (string, error) {
+	var panels map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(registry))
+	decoder.UseNumber()
+	if err := decoder.Decode(&panels); err != nil {
+		return "", fmt.Errorf("failed to decode habpanel panels registry: %s", err)
+	}
+
+	for _, panel := range panels {
+		p, ok := panel.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		dashboards, ok := p["dashboards"].([]interface{})
+		if !ok {
+			continue
+		}
+		kept := make([]interface{}, 0, len(dashboards))
+		for _, dashboard := range dashboards {
+			d, ok := dashboard.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			id, _ := d["id"].(string)
+			name, _ := d["name"].(string)
+			if habpanel.DashboardAllowed(id) || habpanel.DashboardAllowed(name) {
+				kept = append(kept, dashboard)
+			}
+		}
+		p["dashboards"] = kept
+	}
+
+	filtered, err := json.Marshal(panels)
+	if err != nil {
+		return "", err
+	}
+	return string(filtered), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const demoPanelsRegistry = `{"default": {"dashboards": [` +
+	`{"id": "kitchen", "name": "Kitchen", "widgets": []},` +
+	`{"id": "cellar", "name": "Cellar", "widgets": []},` +
+	`{"id": "4711", "name": "Garden", "widgets": []}` +
+	`], "menucolumns": 1}}`
+
+func habpanelRouter(t *testing.T) (*http.ServeMux, func()) {
+	_, mux, closeRemote := testRouter(t, &config.Main{
+		Users: map[string]*config.User{
+			"admin": {Entrypoint: "/start/index", Sitemaps: config.Sitemap{Default: "admin", Allowed: []string{"*"}}, AdminAPI: true},
+			"kid": {
+				Entrypoint: "/habpanel/index.html",
+				Sitemaps:   config.Sitemap{Default: "demo", Allowed: []string{"demo"}},
+				HABPanel:   &config.HABPanel{Dashboards: []string{"kitchen", "Garden"}},
+			},
+			"editor": {
+				Entrypoint: "/habpanel/index.html",
+				Sitemaps:   config.Sitemap{Default: "demo", Allowed: []string{"demo"}},
+				AdminAPI:   true,
+				HABPanel:   &config.HABPanel{Dashboards: []string{"kitchen"}},
+			},
+		},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		registry, _ := json.Marshal(demoPanelsRegistry)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"panelsRegistry": ` + string(registry) + `, "lockEditing": false}`))
+	})
+	return mux, closeRemote
+}
+
+func TestHABPanelDashboardsAreFiltered(t *testing.T) {
+	mux, closeRemote := habpanelRouter(t)
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest(habpanelConfigPath, "kid"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var settings struct {
+		PanelsRegistry string `json:"panelsRegistry"`
+		LockEditing    bool   `json:"lockEditing"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &settings))
+	assert.True(t, settings.LockEditing)
+	assert.JSONEq(t, `{"default": {"dashboards": [
+	  {"id": "kitchen", "name": "Kitchen", "widgets": []},
+	  {"id": "4711", "name": "Garden", "widgets": []}
+	], "menucolumns": 1}}`, settings.PanelsRegistry)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest(habpanelConfigPath, "admin"))
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &settings))
+	assert.False(t, settings.LockEditing)
+	assert.JSONEq(t, demoPanelsRegistry, settings.PanelsRegistry)
+}
+
+func TestRestrictedHABPanelConfigCannotBeSaved(t *testing.T) {
+	mux, closeRemote := habpanelRouter(t)
+	defer closeRemote()
+
+	// the restriction applies even with access to the admin api
+	for user, expected := range map[string]int{"kid": http.StatusForbidden, "editor": http.StatusForbidden, "admin": http.StatusOK} {
+		req := makeRequest(http.MethodPut, habpanelConfigPath, "application/json", `{}`)
+		req.Header.Set("X-Forwarded-Username", user)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		assert.Equal(t, expected, rr.Code, user)
+	}
+}
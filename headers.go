@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultSignatureHeader carries the signed identity, unless configured otherwise
+const defaultSignatureHeader = "X-Router-Signature"
+
+// HeaderData is available to the templates of added headers
+type HeaderData struct {
+	User           string
+	Upstream       string
+	DefaultSitemap string
+	Sitemaps       string
+}
+
+// headerDirector applies the header policy to the upstream request.
+//
+// It runs last, as the other directors rely on the identity header,
+// which may be removed here.
+func headerDirector(req *http.Request, conf *config.Main, upstream string) {
+	user := req.Header.Get("X-Forwarded-Username")
+	userConfig, known := conf.Users[user]
+	if conf.Passthrough || !known {
+		user = ""
+		userConfig = &config.User{}
+	}
+
+	for _, name := range conf.Headers.Remove {
+		req.Header.Del(name)
+	}
+
+	if sign := conf.Headers.Sign; sign != nil {
+		header := sign.Header
+		if header == "" {
+			header = defaultSignatureHeader
+		}
+		if user == "" {
+			req.Header.Del(header)
+		} else {
+			req.Header.Set(header, signIdentity(user, now(), sign.Secret))
+		}
+	}
+
+	data := HeaderData{
+		User:           user,
+		Upstream:       upstream,
+		DefaultSitemap: userConfig.Sitemaps.Default,
+		Sitemaps:       strings.Join(userConfig.Sitemaps.Allowed, ","),
+	}
+	for name, tmpl := range conf.Headers.Templates() {
+		var value bytes.Buffer
+		if err := tmpl.Execute(&value, data); err != nil {
+			log.Error().Err(err).Str("header", name).Msg("failed to render header")
+			req.Header.Del(name)
+			continue
+		}
+		req.Header.Set(name, value.String())
+	}
+}
+
+// signIdentity signs the user and the current time, so downstream services
+// can verify the identity was asserted by the router:
+//
+//	t=<unix timestamp>,user=<name>,sig=<hex encoded HMAC-SHA256 of "<unix timestamp>.<name>">
+func signIdentity(user string, t time.Time, secret string) string {
+	timestamp := fmt.Sprintf("%d", t.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "." + user))
+	return fmt.Sprintf("t=%s,user=%s,sig=%s", timestamp, user, hex.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func headerConfig(t *testing.T) *config.Main {
+	return validConfig(t, &config.Main{
+		Headers: config.Headers{
+			Remove: []string{"X-Forwarded-Username"},
+			Add: map[string]string{
+				"X-Router-User":     "{{.User}}",
+				"X-Router-Sitemaps": "{{.Sitemaps}}",
+			},
+			Sign: &config.Signature{Secret: "secret"},
+		},
+		Users: map[string]*config.User{
+			"demo": {Entrypoint: "/basicui/app", Sitemaps: config.Sitemap{Default: "demo", Allowed: []string{"demo", "widgetoverview"}}},
+		},
+	})
+}
+
+func Test_headerDirector(t *testing.T) {
+	defer setClock(tuesdayMorning)()
+
+	conf := headerConfig(t)
+
+	req := makeGETRequest("/rest/items", "demo")
+	req.Header.Set("X-Router-User", "admin")
+	headerDirector(req, conf, config.DefaultUpstream)
+
+	assert.Empty(t, req.Header.Get("X-Forwarded-Username"))
+	assert.Equal(t, "demo", req.Header.Get("X-Router-User"))
+	assert.Equal(t, "demo,widgetoverview", req.Header.Get("X-Router-Sitemaps"))
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	_, _ = mac.Write([]byte("1583227800.demo"))
+	assert.Equal(t, "t=1583227800,user=demo,sig="+hex.EncodeToString(mac.Sum(nil)), req.Header.Get("X-Router-Signature"))
+}
+
+func TestHeaderDirectorDoesNotSignUnknownUsers(t *testing.T) {
+	conf := headerConfig(t)
+	conf.Passthrough = true
+
+	req := makeGETRequest("/rest/items", "demo")
+	req.Header.Set("X-Router-Signature", "forged")
+	headerDirector(req, conf, config.DefaultUpstream)
+
+	assert.Empty(t, req.Header.Get("X-Router-Signature"))
+	assert.Empty(t, req.Header.Get("X-Router-User"))
+}
+
+func TestHeaderTemplatesFollowTheConfig(t *testing.T) {
+	remoteServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Router-User")))
+	}))
+	defer remoteServer.Close()
+
+	makeConfig := func(template string) *config.Main {
+		conf := &config.Main{
+			Passthrough: true,
+			Headers:     config.Headers{Add: map[string]string{"X-Router-User": template}},
+		}
+		return validConfig(t, conf)
+	}
+	router, err := NewRouter(&Options{Target: remoteServer.URL}, makeConfig("first"))
+	assert.NoError(t, err)
+	mux := router.MakeMux(router.MakeProxy())
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/items", "demo"))
+	assert.Equal(t, "first", rr.Body.String())
+
+	router.SwapConfig(makeConfig("second"))
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/items", "demo"))
+	assert.Equal(t, "second", rr.Body.String())
+}
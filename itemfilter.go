@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+)
+
+// itemResource splits paths like `/rest/items/<name>` or `/rest/things/<uid>`;
+// the name is empty for listings
+func itemResource(path string) (resource string, name string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "rest" || (parts[1] != "items" && parts[1] != "things") {
+		return "", "", false
+	}
+	if len(parts) == 3 {
+		name = parts[2]
+	}
+	return parts[1], name, true
+}
+
+// itemEventsPath streams the events of all items
+const itemEventsPath = "/rest/events"
+
+// itemAccessAllowed checks direct access to a single item like `/rest/items/<name>/state`
+// or its history at `/rest/persistence/items/<name>`
+func itemAccessAllowed(req *http.Request, user *config.User) bool {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) >= 3 && parts[0] == "rest" && parts[1] == "items" {
+		return user.ItemAllowed(parts[2])
+	}
+	if len(parts) >= 4 && parts[0] == "rest" && parts[1] == "persistence" && parts[2] == "items" {
+		return user.ItemAllowed(parts[3])
+	}
+	return true
+}
+
+// filterItemEvents drops the events of items the user may not read from `/rest/events`,
+// just like the events sent over WebSockets
+func (r *Router) filterItemEvents(resp *http.Response) error {
+	conf := r.CurrentConfig()
+	user, ok := conf.Users[requestUser(resp.Request)]
+	if conf.Passthrough || !ok || resp.StatusCode != http.StatusOK ||
+		strings.TrimSuffix(requestURL(resp.Request).Path, "/") != itemEventsPath ||
+		!strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return nil
+	}
+
+	resp.Body = streamFilteredEvents(resp.Body, func(event map[string]interface{}) bool {
+		topic, _ := event["topic"].(string)
+		for _, item := range topicItems(topic) {
+			if !user.ItemAllowed(item) {
+				return false
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+// filterItems removes the items the user may not read from item and thing responses.
+//
+// Listings are processed one element at a time, so installations with
+// thousands of items do not have to be held in memory at once.
+func (r *Router) filterItems(resp *http.Response) error {
+	conf := r.CurrentConfig()
+	user, ok := conf.Users[requestUser(resp.Request)]
+	if conf.Passthrough || !ok || resp.StatusCode != http.StatusOK ||
+		!strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+	resource, name, ok := itemResource(requestURL(resp.Request).Path)
+	if !ok {
+		return nil
+	}
+
+	filter := func(element map[string]interface{}) bool {
+		return filterItem(element, user)
+	}
+	if resource == "things" {
+		filter = func(element map[string]interface{}) bool {
+			filterThing(element, user)
+			return true
+		}
+	}
+
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+
+	if name == "" {
+		resp.Body = streamFilteredArray(resp.Body, filter)
+		return nil
+	}
+
+	var element map[string]interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&element); err != nil {
+		return fmt.Errorf("failed to decode %s '%s': %s", resource, name, err)
+	}
+	_ = resp.Body.Close()
+	filter(element)
+
+	body, err := json.Marshal(element)
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	return nil
+}
+
+// filterItem reports whether the item is readable and removes unreadable group members
+func filterItem(item map[string]interface{}, user *config.User) bool {
+	if name, ok := item["name"].(string); ok && !user.ItemAllowed(name) {
+		return false
+	}
+
+	if members, ok := item["members"].([]interface{}); ok {
+		kept := make([]interface{}, 0, len(members))
+		for _, member := range members {
+			if m, ok := member.(map[string]interface{}); ok && !filterItem(m, user) {
+				continue
+			}
+			kept = append(kept, member)
+		}
+		item["members"] = kept
+	}
+
+	return true
+}
+
+// filterThing removes the links to unreadable items from the channels of a thing
+func filterThing(thing map[string]interface{}, user *config.User) {
+	channels, ok := thing["channels"].([]interface{})
+	if !ok {
+		return
+	}
+
+	for _, channel := range channels {
+		c, ok := channel.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		linked, ok := c["linkedItems"].([]interface{})
+		if !ok {
+			continue
+		}
+		kept := make([]interface{}, 0, len(linked))
+		for _, item := range linked {
+			if name, ok := item.(string); ok && !user.ItemAllowed(name) {
+				continue
+			}
+			kept = append(kept, item)
+		}
+		c["linkedItems"] = kept
+	}
+}
+
+// streamFilteredArray filters the elements of a JSON array while it is read
+func streamFilteredArray(body io.ReadCloser, filter func(map[string]interface{}) bool) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		defer body.Close()
+		_ = writer.CloseWithError(copyFilteredArray(writer, body, filter))
+	}()
+	return reader
+}
+
+func copyFilteredArray(dst io.Writer, src io.Reader, filter func(map[string]interface{}) bool) error {
+	decoder := json.NewDecoder(src)
+	decoder.UseNumber()
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return fmt.Errorf("expected a JSON array")
+	}
+
+	out := bufio.NewWriter(dst)
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+
+	if _, err := out.WriteString("["); err != nil {
+		return err
+	}
+	first := true
+	for decoder.More() {
+		var element map[string]interface{}
+		if err := decoder.Decode(&element); err != nil {
+			return err
+		}
+		if !filter(element) {
+			continue
+		}
+		if !first {
+			if _, err := out.WriteString(","); err != nil {
+				return err
+			}
+		}
+		first = false
+		if err := encoder.Encode(element); err != nil {
+			return err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	if _, err := out.WriteString("]"); err != nil {
+		return err
+	}
+	return out.Flush()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const demoItems = `[
+  {"name": "Light_Kids", "type": "Switch", "state": "ON"},
+  {"name": "Alarm", "type": "Switch", "state": "OFF"},
+  {"name": "Lights", "type": "Group", "members": [
+    {"name": "Light_Kids", "type": "Switch"},
+    {"name": "Alarm", "type": "Switch"}
+  ]}
+]`
+
+const demoThings = `[
+  {"UID": "hue:0210:1", "channels": [
+    {"uid": "hue:0210:1:color", "linkedItems": ["Light_Kids", "Alarm"]}
+  ]}
+]`
+
+const demoItemEvents = "event: message\n" +
+	`data: {"topic":"openhab/items/Light_Kids/statechanged","payload":"{\"value\":\"ON\"}","type":"ItemStateChangedEvent"}` + "\n\n" +
+	"event: message\n" +
+	`data: {"topic":"openhab/items/Alarm/statechanged","payload":"{\"value\":\"ON\"}","type":"ItemStateChangedEvent"}` + "\n\n" +
+	"event: message\n" +
+	`data: {"topic":"openhab/things/hue:0210:1/status","payload":"{}","type":"ThingStatusInfoEvent"}` + "\n\n"
+
+func itemRouter(t *testing.T) (*http.ServeMux, func()) {
+	denied := false
+	_, mux, closeRemote := testRouter(t, &config.Main{
+		Users: map[string]*config.User{
+			"guest": {
+				Entrypoint: "/basicui/app",
+				Sitemaps:   config.Sitemap{Default: "demo", Allowed: []string{"demo"}},
+				Items: map[string]*config.Item{
+					"Alarm": {Allowed: &denied},
+				},
+			},
+		},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/rest/events":
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(demoItemEvents))
+		case "/rest/items":
+			_, _ = w.Write([]byte(demoItems))
+		case "/rest/items/Lights":
+			_, _ = w.Write([]byte(`{"name": "Lights", "type": "Group", "members": [{"name": "Alarm"}, {"name": "Light_Kids"}]}`))
+		case "/rest/things":
+			_, _ = w.Write([]byte(demoThings))
+		default:
+			_, _ = w.Write([]byte(`{"name": "Light_Kids"}`))
+		}
+	})
+	return mux, closeRemote
+}
+
+func TestItemListingsAreFiltered(t *testing.T) {
+	mux, closeRemote := itemRouter(t)
+	defer closeRemote()
+
+	tests := []struct {
+		uri      string
+		expected string
+	}{
+		{
+			uri: "/rest/items?recursive=true",
+			expected: `[
+			  {"name": "Light_Kids", "type": "Switch", "state": "ON"},
+			  {"name": "Lights", "type": "Group", "members": [{"name": "Light_Kids", "type": "Switch"}]}
+			]`,
+		},
+		{
+			uri:      "/rest/items/Lights",
+			expected: `{"name": "Lights", "type": "Group", "members": [{"name": "Light_Kids"}]}`,
+		},
+		{
+			uri: "/rest/things",
+			expected: `[
+			  {"UID": "hue:0210:1", "channels": [{"uid": "hue:0210:1:color", "linkedItems": ["Light_Kids"]}]}
+			]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, makeGETRequest(tt.uri, "guest"))
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.JSONEq(t, tt.expected, rr.Body.String())
+		})
+	}
+}
+
+func TestDeniedItemsCannotBeRead(t *testing.T) {
+	mux, closeRemote := itemRouter(t)
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/items/Alarm/state", "guest"))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/persistence/items/Alarm?serviceId=rrd4j", "guest"))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/items/Light_Kids", "guest"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/persistence/items/Light_Kids", "guest"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestItemEventsAreFiltered(t *testing.T) {
+	mux, closeRemote := itemRouter(t)
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/events", "guest"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Light_Kids")
+	assert.Contains(t, rr.Body.String(), "hue:0210:1")
+	assert.NotContains(t, rr.Body.String(), "Alarm")
+}
+
+func Test_copyFilteredArray(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		err      bool
+	}{
+		{name: "empty", input: `[]`, expected: `[]`},
+		{name: "all removed", input: `[{"name": "Alarm"}]`, expected: `[]`},
+		{name: "kept as is", input: `[{"name": "A&B", "state": 21.50}]`, expected: `[{"name":"A&B","state":21.50}]`},
+		{name: "no array", input: `{"name": "Alarm"}`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := copyFilteredArray(&out, strings.NewReader(tt.input), func(element map[string]interface{}) bool {
+				return element["name"] != "Alarm"
+			})
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, strings.Replace(out.String(), "\n", "", -1))
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation
+const listenFDsStart = 3
+
+// unixSocketSuffix marks hosts which stand for a Unix socket of an upstream
+const unixSocketSuffix = ".unix"
+
+// Listen opens the listener of the router: the socket passed by systemd, the
+// Unix socket given by `-socket` or the TCP address given by `-host` and `-port`
+func (r *Router) Listen() (net.Listener, error) {
+	listener, err := activatedListener(listenFDsStart)
+	if err != nil || listener != nil {
+		return listener, err
+	}
+
+	if r.Opts.Socket != "" {
+		return listenUnix(r.Opts.Socket, r.Opts.SocketMode)
+	}
+
+	return net.Listen("tcp", fmt.Sprintf("%s:%s", r.Opts.Host, r.Opts.Port))
+}
+
+// activatedListener returns the socket passed by systemd, if the process was socket activated
+func activatedListener(start int) (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	// the variables must not be inherited by child processes
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	if fds > 1 {
+		log.Warn().Int("fds", fds).Msg("only the first socket passed by systemd is used")
+	}
+	file := os.NewFile(uintptr(start), "LISTEN_FD_"+strconv.Itoa(start))
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the socket passed by systemd: %s", err)
+	}
+	log.Info().Str("address", listener.Addr().String()).Msg("using socket passed by systemd")
+	return listener, nil
+}
+
+// listenUnix listens on a Unix socket with the given octal file mode, e.g. `0660`;
+// a socket left over by a previous run is replaced
+func listenUnix(path string, mode string) (net.Listener, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket mode '%s', expected an octal mode like '0660'", mode)
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// parseTarget parses the address of a backend; Unix sockets like
+// `unix:///run/openhab.sock` are addressed by a host standing for the socket
+func parseTarget(target string) (*url.URL, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "unix" {
+		return u, err
+	}
+	path := u.Path
+	if path == "" {
+		path = u.Opaque
+	}
+	return &url.URL{Scheme: "http", Host: hex.EncodeToString([]byte(path)) + unixSocketSuffix}, nil
+}
+
+// unixSocketPath returns the socket a host returned by parseTarget stands for
+func unixSocketPath(addr string) (string, bool) {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	if !strings.HasSuffix(host, unixSocketSuffix) {
+		return "", false
+	}
+	path, err := hex.DecodeString(strings.TrimSuffix(host, unixSocketSuffix))
+	if err != nil {
+		return "", false
+	}
+	return string(path), true
+}
+
+// dialBackend connects to Unix sockets of backends and to TCP addresses otherwise
+func dialBackend(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if path, ok := unixSocketPath(addr); ok {
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// proxyBackend applies proxies from the environment, but not to Unix sockets
+func proxyBackend(req *http.Request) (*url.URL, error) {
+	if _, ok := unixSocketPath(req.URL.Host); ok {
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
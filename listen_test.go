@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		socket string
+	}{
+		{target: "unix:///run/openhab/openhab.sock", socket: "/run/openhab/openhab.sock"},
+		{target: "unix:/run/openhab.sock", socket: "/run/openhab.sock"},
+		{target: "unix:openhab.sock", socket: "openhab.sock"},
+		{target: "http://openhab:8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			u, err := parseTarget(tt.target)
+			assert.NoError(t, err)
+			socket, ok := unixSocketPath(u.Host + ":80")
+			assert.Equal(t, tt.socket != "", ok)
+			assert.Equal(t, tt.socket, socket)
+		})
+	}
+}
+
+func TestListenOnUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "router.sock")
+
+	// a socket left over by a previous run is replaced
+	stale, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	router := &Router{Opts: &Options{Socket: path, SocketMode: "0600"}}
+	listener, err := router.Listen()
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// the client address is taken from the header of the local proxy
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(clientIP(r, &config.Main{}).String()))
+	})}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	req, _ := http.NewRequest(http.MethodGet, "http://router/", nil)
+	req.Header.Set("X-Forwarded-For", "192.168.1.10")
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "192.168.1.10", string(body))
+}
+
+func TestListenOnActivatedSocket(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer tcp.Close()
+	file, err := tcp.(*net.TCPListener).File()
+	assert.NoError(t, err)
+	defer file.Close()
+
+	// the listener takes ownership of the descriptor it is passed
+	fd, err := syscall.Dup(int(file.Fd()))
+	assert.NoError(t, err)
+
+	_ = os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	_ = os.Setenv("LISTEN_FDS", "1")
+	listener, err := activatedListener(fd)
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	assert.Equal(t, tcp.Addr().String(), listener.Addr().String())
+	assert.Equal(t, "", os.Getenv("LISTEN_FDS"))
+
+	// without the variables, there is no activated socket
+	listener, err = activatedListener(fd)
+	assert.NoError(t, err)
+	assert.Nil(t, listener)
+}
+
+func TestUpstreamOnUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "openhab.sock")
+
+	unixListener, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	remoteServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	remoteServer.Listener = unixListener
+	remoteServer.Start()
+	defer remoteServer.Close()
+
+	router, err := NewRouter(&Options{Target: "unix://" + path}, validConfig(t, &config.Main{Passthrough: true}))
+	assert.NoError(t, err)
+	mux := router.MakeMux(router.MakeProxy())
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/items", ""))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "/rest/items", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/readiness", ""))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
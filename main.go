@@ -1,28 +1,38 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/hendrikmaus/openhab-auth-router/config"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"gopkg.in/yaml.v2"
 )
 
 type Options struct {
-	Host           string
-	Port           string
-	Target         string
-	ConfigFilePath string
-	LogLevel       string
+	Host            string
+	Port            string
+	Target          string
+	ConfigFilePath  string
+	LogLevel        string
+	Socket          string
+	SocketMode      string
+	DrainPeriod     time.Duration
+	ShutdownTimeout time.Duration
+	AdminListen     string
+	AdminTokenFile  string
 }
 
 func (o *Options) Validate() error {
@@ -30,105 +40,303 @@ func (o *Options) Validate() error {
 		return errors.New("please set '-target' to the address of your openHAB instance, e.g. 'http://openhab:8080'")
 	}
 
+	if _, err := strconv.ParseUint(o.SocketMode, 8, 32); o.Socket != "" && err != nil {
+		return errors.New("please set '-socket-mode' to an octal file mode, e.g. '0660'")
+	}
+
 	if o.ConfigFilePath == "" {
-		return errors.New("please set '-config' to the path of your config.yaml file")
+		return errors.New("please set '-config' to the path of your config.yaml file or a directory of config files")
+	}
+
+	if o.AdminListen != "" && o.AdminTokenFile == "" {
+		return errors.New("please set '-admin-token-file' to the path of a file containing the token of the admin API")
 	}
 
 	return nil
 }
 
 type Router struct {
-	Log  zerolog.Logger
-	Opts *Options
-	Config *config.Main
+	Log        zerolog.Logger
+	Opts       *Options
+	Limiter    *Limiter
+	Upstreams  map[string]*Pool
+	Pages      *PageFilter
+	ErrorPages *ErrorPages
+
+	// config is read through CurrentConfig, as the admin API swaps it at runtime
+	config   *config.Main
+	configMu sync.RWMutex
+
+	// transport carries the proxied requests and those the router makes on behalf of users
+	transport http.RoundTripper
+	drain     drainState
 }
 
-func main() {
-	opts := &Options{}
-	flag.StringVar(&opts.Host, "host", "127.0.0.1", "Host to listen on")
-	flag.StringVar(&opts.Port, "port", "80", "Port to listen on")
-	flag.StringVar(&opts.Target, "target", "", "Address of your openHAB instance, e.g. 'http://openhab:8080'")
-	flag.StringVar(&opts.ConfigFilePath, "config", "", "Path to config.yaml")
-	flag.StringVar(&opts.LogLevel, "log-level", "info", "Loglevel as in [error|warn|info|debug]")
-	flag.Parse()
+// CurrentConfig returns the config in effect; it must not be modified
+func (r *Router) CurrentConfig() *config.Main {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	return r.config
+}
 
+// SwapConfig puts the config into effect for the following requests
+func (r *Router) SwapConfig(conf *config.Main) {
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+	r.config = conf
+}
+
+// NewRouter sets up the router for the options and the validated config
+func NewRouter(opts *Options, conf *config.Main) (*Router, error) {
+	router := &Router{
+		Log:     newLogger(opts.LogLevel),
+		Opts:    opts,
+		config:  conf,
+		Limiter: NewLimiter(),
+		Pages:   NewPageFilter(),
+	}
+
+	upstreams, err := router.MakeUpstreams()
+	if err != nil {
+		return nil, err
+	}
+	router.Upstreams = upstreams
+	router.transport = &ejectingTransport{RoundTripper: router.MakeTransport(), router: router}
+
+	router.ErrorPages, err = NewErrorPages(conf.ErrorPages)
+	if err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+// newLogger writes to stderr at the level given by the `-log-level` option
+func newLogger(level string) zerolog.Logger {
 	logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
 
-	switch opts.LogLevel {
+	switch level {
 	case "error":
-		logger = logger.Level(zerolog.ErrorLevel)
+		return logger.Level(zerolog.ErrorLevel)
 	case "warn":
-		logger = logger.Level(zerolog.WarnLevel)
-	case "info":
-		logger = logger.Level(zerolog.InfoLevel)
+		return logger.Level(zerolog.WarnLevel)
 	case "debug":
-		logger = logger.Level(zerolog.DebugLevel)
+		return logger.Level(zerolog.DebugLevel)
 	default:
-		logger = logger.Level(zerolog.InfoLevel)
+		return logger.Level(zerolog.InfoLevel)
 	}
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		printSchema()
+		return
+	}
+
+	opts := &Options{}
+	flag.StringVar(&opts.Host, "host", "127.0.0.1", "Host to listen on")
+	flag.StringVar(&opts.Port, "port", "80", "Port to listen on")
+	flag.StringVar(&opts.Target, "target", "", "Address of your openHAB instance, e.g. 'http://openhab:8080'; separate several backends by comma for failover")
+	flag.StringVar(&opts.ConfigFilePath, "config", "", "Path to config.yaml, config.json, config.toml or a directory of such files")
+	flag.StringVar(&opts.LogLevel, "log-level", "info", "Loglevel as in [error|warn|info|debug]")
+	flag.StringVar(&opts.Socket, "socket", "", "Path of a Unix socket to listen on instead of host and port")
+	flag.StringVar(&opts.SocketMode, "socket-mode", "0660", "File mode of the Unix socket")
+	flag.DurationVar(&opts.DrainPeriod, "drain-period", 5*time.Second, "Time between failing readiness and closing the listener on shutdown")
+	flag.DurationVar(&opts.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "Time in-flight requests may take to complete on shutdown")
+	flag.StringVar(&opts.AdminListen, "admin-listen", "", "Address of the admin API, e.g. '127.0.0.1:9090'; disabled if empty")
+	flag.StringVar(&opts.AdminTokenFile, "admin-token-file", "", "Path to a file containing the bearer token of the admin API")
+	flag.Parse()
 
 	if err := opts.Validate(); err != nil {
 		log.Fatal().Err(err).Msg("invalid options, exiting")
 	}
 
-	conf := &config.Main{}
-	data, err := ioutil.ReadFile(opts.ConfigFilePath)
+	conf, redacted, err := readConfig(opts.ConfigFilePath)
 	if err != nil {
-		log.Fatal().Msgf("could not read config file '%s'", opts.ConfigFilePath)
-	}
-
-	if err := yaml.Unmarshal(data, conf); err != nil {
-		log.Fatal().Msg("could not parse config file, please ensure it is valid YAML")
+		log.Fatal().Err(err).Msgf("could not read config from '%s'", opts.ConfigFilePath)
 	}
 
 	if err := config.Validate(conf); err != nil {
 		log.Fatal().Msg("failed to validate config")
 	}
 
-	log.Debug().Interface("config", opts).Msg("processed configuration")
+	if err := config.ResolveCredentials(conf); err != nil {
+		log.Fatal().Err(err).Msg("failed to resolve upstream credentials")
+	}
 
-	router := &Router{
-		Log:  logger,
-		Opts: opts,
-		Config: conf,
+	log.Debug().Interface("options", opts).Interface("config", redacted).Msg("processed configuration")
+
+	router, err := NewRouter(opts, conf)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to set up the router, exiting")
 	}
 
+	stop := make(chan struct{})
+	router.StartHealthChecks(stop)
+
 	proxy := router.MakeProxy()
 	mux := router.MakeMux(proxy)
 
-	log.Info().Str("host", router.Opts.Host).Str("port", router.Opts.Port).Msg("serving")
-	if err := http.ListenAndServe(fmt.Sprintf("%s:%s", router.Opts.Host, router.Opts.Port), mux); err != nil {
+	listener, err := router.Listen()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to listen")
+	}
+	server := router.MakeServer(listener.Addr().String(), mux)
+
+	if opts.AdminListen != "" {
+		admin, err := NewAdminServer(router, opts.ConfigFilePath, opts.AdminTokenFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid admin api, exiting")
+		}
+		adminServer := router.MakeServer(opts.AdminListen, admin)
+		server.RegisterOnShutdown(func() {
+			_ = adminServer.Close()
+		})
+		go func() {
+			log.Info().Str("address", opts.AdminListen).Msg("serving admin api")
+			if err := adminServer.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal().Err(err).Msg("failed serving admin api")
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		log.Info().Str("signal", sig.String()).Msg("received signal")
+		if err := router.Shutdown(server, stop); err != nil {
+			log.Error().Err(err).Msg("failed to shut down gracefully")
+		}
+	}()
+
+	log.Info().Str("address", listener.Addr().String()).Msg("serving")
+	if err := server.Serve(listener); err != http.ErrServerClosed {
 		log.Fatal().Err(err).Msg("failed serving")
 	}
+	<-done
+	log.Info().Msg("stopped")
 }
 
 func (r *Router) MakeProxy() *httputil.ReverseProxy {
-	remote, err := url.Parse(r.Opts.Target)
-	if err != nil {
-		log.Fatal().Msg("failed to parse given target")
-	}
-	proxy := httputil.NewSingleHostReverseProxy(remote)
-	defaultDirector := proxy.Director
+	proxy := &httputil.ReverseProxy{}
 	proxy.Director = func(req *http.Request) {
-		defaultDirector(req)
-		ruleDirector(req, r.Config)
+		conf := r.CurrentConfig()
+		upstream := r.routeRequest(req, conf)
+		credentialsDirector(req, conf)
+		headerDirector(req, conf, upstream)
+		if conf.BasePath != "" || !conf.Passthrough {
+			// responses are rewritten, so they must not be compressed
+			req.Header.Del("Accept-Encoding")
+		}
+		if !conf.Passthrough {
+			// websocket messages are filtered, so they must not be compressed either
+			req.Header.Del("Sec-WebSocket-Extensions")
+		}
+	}
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if err := r.filterSitemap(resp); err != nil {
+			return err
+		}
+		if err := r.filterItems(resp); err != nil {
+			return err
+		}
+		if err := r.filterItemEvents(resp); err != nil {
+			return err
+		}
+		if err := r.filterHABPanel(resp); err != nil {
+			return err
+		}
+		if err := r.filterMainUI(resp); err != nil {
+			return err
+		}
+		if err := r.filterWebSocket(resp); err != nil {
+			return err
+		}
+		return rewriteBasePath(resp, r.CurrentConfig().BasePath)
 	}
+	proxy.Transport = r.transport
+	proxy.FlushInterval = flushInterval(r.CurrentConfig())
 	return proxy
 }
 
+// routeRequest makes the decisions of the router on a request to be proxied:
+// it selects the upstream, applies the rules of the user and points the
+// request at a backend of the upstream; it returns the name of the upstream
+func (r *Router) routeRequest(req *http.Request, conf *config.Main) string {
+	upstream := upstreamName(req, conf)
+	// the rules apply to the path sent by the client, not the one joined with the target
+	ruleDirector(req, conf)
+	r.pageDirector(req)
+	routed := *req.URL
+	*req = *req.WithContext(context.WithValue(req.Context(), urlContextKey, &routed))
+	// only configured users are pinned to a backend, as clients choose the
+	// username themselves in passthrough mode
+	user := req.Header.Get("X-Forwarded-Username")
+	if _, ok := conf.Users[user]; !ok {
+		user = ""
+	}
+	backend := r.Upstreams[upstream].Select(user)
+	targetDirector(req, backend.URL)
+	return upstream
+}
+
 func (r *Router) MakeMux(proxy *httputil.ReverseProxy) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/liveness", r.LivenessProbeHandler)
 
-	remote, err := url.Parse(r.Opts.Target)
-	if err != nil {
-		log.Fatal().Msg("failed to parse given target")
-	}
+	// an unreachable upstream only affects the users routed to it, so
+	// it must not take the whole router out of traffic
 	mux.HandleFunc("/readiness", func(w http.ResponseWriter, req *http.Request) {
-		r.ReadinessProbeHandler(w, req, remote)
+		r.ReadinessProbeHandler(w, req, r.Upstreams[config.DefaultUpstream].Current().URL)
+	})
+	mux.HandleFunc("/readiness/", func(w http.ResponseWriter, req *http.Request) {
+		pool, ok := r.Upstreams[strings.TrimPrefix(req.URL.Path, "/readiness/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		r.ReadinessProbeHandler(w, req, pool.Current().URL)
 	})
+	portal, err := MakePortal(r.CurrentConfig().Portal)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid portal")
+	}
+	connections := NewConnectionLimiter()
 	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
-		mainHandler(w, req, r.Config, proxy)
+		conf := r.CurrentConfig()
+		stripBasePath(req, conf.BasePath)
+		if r.rateLimited(w, req) {
+			return
+		}
+		if bodyTooLarge(w, req, r.ErrorPages, conf) {
+			return
+		}
+		if r.servePortal(w, req, portal) {
+			return
+		}
+
+		var cancel context.CancelFunc
+		if isStream(req) {
+			req, cancel = r.withStreamContext(req)
+		} else {
+			req, cancel = withRequestTimeout(req, conf)
+		}
+		defer cancel()
+
+		if isWebSocketUpgrade(req) {
+			user := req.Header.Get("X-Forwarded-Username")
+			release, ok := connections.Acquire(user, conf.WebSockets)
+			if !ok {
+				log.Debug().Str("user", user).Str("uri", req.URL.RequestURI()).Msg("websocket connection limit exceeded")
+				r.ErrorPages.Write(w, req, http.StatusTooManyRequests, "Too many open connections, please retry later.", nil)
+				return
+			}
+			// the proxy only returns once the connection is closed
+			defer release()
+		}
+		mainHandler(w, req, r.ErrorPages, conf, proxy)
 	})
 
 	return mux
@@ -141,13 +349,19 @@ func (r *Router) LivenessProbeHandler(w http.ResponseWriter, req *http.Request)
 }
 
 // ReadinessProbeHandler asserts connection to downstream dependencies
-func (r *Router) ReadinessProbeHandler(w http.ResponseWriter, req *http.Request, remote *url.URL) {
-	resp, err := http.Get(remote.String() + "/rest/")
-	if err != nil || resp.StatusCode != http.StatusOK {
-		r.Log.Err(err).Str("probe", "readiness").Str("remote", remote.String()).Msg("failed to assert target access")
+func (r *Router) ReadinessProbeHandler(w http.ResponseWriter, req *http.Request, remotes ...*url.URL) {
+	if r.drain.isDraining() {
+		r.Log.Debug().Str("probe", "readiness").Msg("draining")
 		w.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
+	for _, remote := range remotes {
+		if err := probe(readinessClient, remote); err != nil {
+			r.Log.Err(err).Str("probe", "readiness").Str("remote", remote.String()).Msg("failed to assert target access")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
 	r.Log.Debug().Str("probe", "readiness").Msg("")
 	w.WriteHeader(http.StatusOK)
 }
@@ -173,6 +387,9 @@ func ruleDirector(req *http.Request, conf *config.Main) {
 			if pathConfig.Allowed == false {
 				logger.Debug().Msgf("redirecting to default entrypoint %s - denying access to %s", conf.Users[user].Entrypoint, req.URL.RequestURI())
 				req.URL.Path = conf.Users[user].Entrypoint
+			} else if met, condition := conditionsMet(pathConfig.Conditions, req, conf); !met {
+				logger.Debug().Str("condition", condition).Msgf("redirecting to default entrypoint %s - condition not met for %s", conf.Users[user].Entrypoint, req.URL.RequestURI())
+				req.URL.Path = conf.Users[user].Entrypoint
 			}
 		}
 	}
@@ -188,14 +405,9 @@ func ruleDirector(req *http.Request, conf *config.Main) {
 			return
 		}
 		if sitemap != "" && sitemap != conf.Users[user].Sitemaps.Default {
-			if len(conf.Users[user].Sitemaps.Allowed) == 1 && conf.Users[user].Sitemaps.Allowed[0] == "*" {
+			if sitemapAllowed(conf.Users[user], sitemap, req, conf) {
 				return
 			}
-			for _, allowedSitemap := range conf.Users[user].Sitemaps.Allowed {
-				if sitemap == allowedSitemap {
-					return
-				}
-			}
 			queryString.Set("sitemap", conf.Users[user].Sitemaps.Default)
 			req.URL.RawQuery = queryString.Encode()
 			logger.Debug().Msgf("redirecting to default sitemap %s - denying access to requested sitemap %s", conf.Users[user].Sitemaps.Default, sitemap)
@@ -206,22 +418,26 @@ func ruleDirector(req *http.Request, conf *config.Main) {
 	// Handle rest access
 	if strings.HasPrefix(req.URL.RequestURI(), "/rest") {
 		if strings.HasPrefix(req.URL.RequestURI(), "/rest/sitemaps/events") {
+			queryString := req.URL.Query()
+			sitemap := queryString.Get("sitemap")
+			if sitemap != "" && sitemap != conf.Users[user].Sitemaps.Default && !sitemapAllowed(conf.Users[user], sitemap, req, conf) {
+				queryString.Set("sitemap", conf.Users[user].Sitemaps.Default)
+				queryString.Set("pageid", conf.Users[user].Sitemaps.Default)
+				req.URL.RawQuery = queryString.Encode()
+				logger.Debug().Msgf("redirecting to default sitemap %s - denying events of requested sitemap %s", conf.Users[user].Sitemaps.Default, sitemap)
+			}
 			return
 		}
 		if strings.HasPrefix(req.URL.RequestURI(), "/rest/sitemaps/_default") {
-			req.URL.Path = "/rest/sitemaps/"+conf.Users[user].Sitemaps.Default
+			req.URL.Path = "/rest/sitemaps/" + conf.Users[user].Sitemaps.Default
 			return
 		}
 		if strings.HasPrefix(req.URL.RequestURI(), "/rest/sitemaps/") {
-			if len(conf.Users[user].Sitemaps.Allowed) == 1 && conf.Users[user].Sitemaps.Allowed[0] == "*" {
+			parts := strings.Split(req.URL.RequestURI(), "/")
+			sitemap := strings.Split(req.URL.Path, "/")[3]
+			if sitemap == conf.Users[user].Sitemaps.Default || sitemapAllowed(conf.Users[user], sitemap, req, conf) {
 				return
 			}
-			for _, allowedSitemap := range conf.Users[user].Sitemaps.Allowed {
-				if strings.Contains(req.URL.RequestURI(), allowedSitemap) {
-					return
-				}
-			}
-			parts := strings.Split(req.URL.RequestURI(), "/")
 			defaultSitemapURL := strings.Replace(req.URL.RequestURI(), parts[3], conf.Users[user].Sitemaps.Default, -1)
 			logger.Debug().Msgf("redirecting to default sitemap %s - denying access to requested resource %s via REST API call", conf.Users[user].Sitemaps.Default, req.URL.RequestURI())
 			req.URL.Path = defaultSitemapURL
@@ -230,58 +446,113 @@ func ruleDirector(req *http.Request, conf *config.Main) {
 	}
 }
 
-func mainHandler(w http.ResponseWriter, req *http.Request, conf *config.Main, proxy *httputil.ReverseProxy) {
+func mainHandler(w http.ResponseWriter, req *http.Request, pages *ErrorPages, conf *config.Main, proxy *httputil.ReverseProxy) {
 	if conf.Passthrough == false {
 		user := req.Header.Get("X-Forwarded-Username")
 		if user == "" && conf.Passthrough == false {
-			failRequest(w, req, "the header 'X-Forwarded-Username' is either not set or empty")
+			failRequest(w, req, pages, "the header 'X-Forwarded-Username' is either not set or empty")
 			return
 		}
 
 		_, ok := conf.Users[user]
 		if ok == false {
 			log.Debug().Str("user", user).Str("uri", req.URL.RequestURI()).Msg("user not found")
-			w.WriteHeader(403)
+			forbid(w, req, pages, conf, user, "The user is not known to the router.")
 			return
 		}
-	}
 
-	proxy.ServeHTTP(w, req)
-}
+		if met, condition := conditionsMet(conf.Users[user].Conditions, req, conf); !met {
+			log.Debug().Str("user", user).Str("uri", req.URL.RequestURI()).Str("condition", condition).Msg("user condition not met")
+			forbid(w, req, pages, conf, user, fmt.Sprintf("Access is currently not granted due to the %s restriction.", condition))
+			return
+		}
 
-func failRequest(w http.ResponseWriter, r *http.Request, message string) {
-	if message != "" {
-		log.Error().Msg(message)
-	}
-	contentType := r.Header.Get("Content-Type")
+		if !conf.Users[user].AdminAPI && isAdminRequest(req, conf.OpenHABVersion) {
+			log.Debug().Str("user", user).Str("uri", req.URL.RequestURI()).Msg("admin api denied")
+			forbid(w, req, pages, conf, user, "The administrative API is not available to the user.")
+			return
+		}
 
-	switch {
-	case strings.Contains(contentType, "text/html"):
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.WriteHeader(http.StatusBadRequest)
-		if message != "" {
-			_, err := fmt.Fprint(w, message)
-			if err != nil {
-				log.Fatal().Err(err).Msg("")
-			}
+		if !habpanelAccessAllowed(req, conf.Users[user]) {
+			log.Debug().Str("user", user).Str("uri", req.URL.RequestURI()).Msg("habpanel config is read-only")
+			forbid(w, req, pages, conf, user, "The HABPanel configuration is read-only for the user.")
+			return
 		}
-	case strings.Contains(contentType, "application/json"):
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.WriteHeader(http.StatusBadRequest)
-		if message != "" {
-			_, err := fmt.Fprintf(w, "{\"error\":\"%s\"", message)
-			if err != nil {
-				log.Fatal().Err(err).Msg("")
-			}
+
+		if !mainUIAccessAllowed(req, conf.Users[user]) {
+			log.Debug().Str("user", user).Str("uri", req.URL.RequestURI()).Msg("main ui component denied")
+			forbid(w, req, pages, conf, user, "The Main UI component is not available to the user.")
+			return
 		}
-	default:
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusBadRequest)
-		if message != "" {
-			_, err := w.Write([]byte(message))
-			if err != nil {
-				log.Fatal().Err(err).Msg("")
+
+		if location, ok := mainUIRedirect(req, conf.Users[user]); ok {
+			log.Debug().Str("user", user).Str("uri", req.URL.RequestURI()).Msgf("redirecting to main ui entrypoint %s", location)
+			http.Redirect(w, req, conf.BasePath+location, http.StatusFound)
+			return
+		}
+
+		if !itemAccessAllowed(req, conf.Users[user]) {
+			log.Debug().Str("user", user).Str("uri", req.URL.RequestURI()).Msg("item denied")
+			forbid(w, req, pages, conf, user, "The item is not available to the user.")
+			return
+		}
+
+		if sitemap, ok := forbiddenSitemap(req, conf.Users[user], conf); ok {
+			log.Debug().Str("user", user).Str("sitemap", sitemap).Msg("sitemap denied")
+			forbid(w, req, pages, conf, user, fmt.Sprintf("You don't have access to the sitemap '%s'.", sitemap))
+			return
+		}
+
+		commands, err := itemCommands(req)
+		if err != nil {
+			failRequest(w, req, pages, "could not read the command sent with the request")
+			return
+		}
+		for item, command := range commands {
+			if !commandAllowed(conf.Users[user], item, command) {
+				log.Debug().Str("user", user).Str("item", item).Str("command", command).Msg("command denied")
+				forbid(w, req, pages, conf, user, fmt.Sprintf("The command '%s' is not allowed for item '%s'.", command, item))
+				return
 			}
 		}
+
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+	}
+
+	proxy.ServeHTTP(w, req)
+}
+
+type contextKey string
+
+// userContextKey stores the user on the request, as the identity header
+// may be removed before the request is sent upstream
+const userContextKey = contextKey("user")
+
+// urlContextKey stores the URL of a proxied request before the path of
+// the upstream's target is prepended
+const urlContextKey = contextKey("url")
+
+// requestURL is the URL of a proxied request as the router decided on it,
+// relative to the target of the upstream
+func requestURL(req *http.Request) *url.URL {
+	if u, ok := req.Context().Value(urlContextKey).(*url.URL); ok {
+		return u
+	}
+	return req.URL
+}
+
+// requestUser is the user a request or response belongs to
+func requestUser(req *http.Request) string {
+	if user, ok := req.Context().Value(userContextKey).(string); ok {
+		return user
+	}
+	return req.Header.Get("X-Forwarded-Username")
+}
+
+// failRequest answers with HTTP 400 in the format the client accepts
+func failRequest(w http.ResponseWriter, r *http.Request, pages *ErrorPages, message string) {
+	if message != "" {
+		log.Error().Msg(message)
 	}
+	pages.Write(w, r, http.StatusBadRequest, message, nil)
 }
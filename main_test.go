@@ -82,7 +82,7 @@ func TestMissingHeaderInNonPassthroughModeFails(t *testing.T) {
 	conf := config.Main{Passthrough: false}
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		mainHandler(w, r, &conf, nil)
+		mainHandler(w, r, defaultErrorPages(), &conf, nil)
 	})
 	handler.ServeHTTP(rr, req)
 
@@ -107,7 +107,7 @@ func TestPassthrough(t *testing.T) {
 	conf := config.Main{Passthrough: true}
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		mainHandler(w, r, &conf, proxy)
+		mainHandler(w, r, defaultErrorPages(), &conf, proxy)
 	})
 	handler.ServeHTTP(rr, req)
 
@@ -124,7 +124,7 @@ func TestUnknownUserIsBlocked(t *testing.T) {
 	conf := config.Main{Passthrough: false}
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		mainHandler(w, r, &conf, nil)
+		mainHandler(w, r, defaultErrorPages(), &conf, nil)
 	})
 	handler.ServeHTTP(rr, req)
 
@@ -187,6 +187,25 @@ func Test_ruleDirector(t *testing.T) {
 			},
 			expectedRequestURI: "/start/index",
 		},
+		{
+			name: "rest - events of a denied sitemap are replaced by the default sitemap",
+			args: args{
+				req: makeGETRequest("/rest/sitemaps/events/1?sitemap=alarm&pageid=0100", "test"),
+				conf: &config.Main{
+					Passthrough: false,
+					Users:       map[string]*config.User{
+						"test": {
+							Entrypoint: "/start/index",
+							Sitemaps: config.Sitemap{
+								Default: "defaultSitemap",
+								Allowed: []string{"defaultSitemap"},
+							},
+						},
+					},
+				},
+			},
+			expectedRequestURI: "/rest/sitemaps/events/1?pageid=defaultSitemap&sitemap=defaultSitemap",
+		},
 		{
 			name: "basicui - user is redirected to default sitemap when none is requested",
 			args: args{
@@ -417,3 +436,23 @@ func makeGETRequest(uri string, user string) *http.Request {
 	r.Header.Add("X-Forwarded-Username", user)
 	return r
 }
+
+// validConfig fails the test unless the config is valid; the router only
+// works with validated configs
+func validConfig(t *testing.T, conf *config.Main) *config.Main {
+	if err := config.Validate(conf); err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+// testRouter serves the config in front of a fake openHAB answering with the handler
+func testRouter(t *testing.T, conf *config.Main, upstream http.HandlerFunc) (*Router, *http.ServeMux, func()) {
+	remoteServer := httptest.NewServer(upstream)
+	router, err := NewRouter(&Options{Target: remoteServer.URL}, validConfig(t, conf))
+	if err != nil {
+		remoteServer.Close()
+		t.Fatal(err)
+	}
+	return router, router.MakeMux(router.MakeProxy()), remoteServer.Close
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+)
+
+const (
+	mainUIPages   = "ui:page"
+	mainUIWidgets = "ui:widget"
+)
+
+// mainUIComponent splits paths like `/rest/ui/components/ui:page/<uid>`;
+// the uid is empty for listings
+func mainUIComponent(path string) (namespace string, uid string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 4 || len(parts) > 5 || parts[0] != "rest" || parts[1] != "ui" || parts[2] != "components" {
+		return "", "", false
+	}
+	if parts[3] != mainUIPages && parts[3] != mainUIWidgets {
+		return "", "", false
+	}
+	if len(parts) == 5 {
+		uid = parts[4]
+	}
+	return parts[3], uid, true
+}
+
+func mainUIComponentAllowed(mainUI *config.MainUI, namespace string, uid string) bool {
+	if namespace == mainUIWidgets {
+		return mainUI.WidgetAllowed(uid)
+	}
+	return mainUI.PageAllowed(uid)
+}
+
+// mainUIAccessAllowed checks direct access to a single page or widget
+func mainUIAccessAllowed(req *http.Request, user *config.User) bool {
+	namespace, uid, ok := mainUIComponent(req.URL.Path)
+	if !ok || uid == "" {
+		return true
+	}
+	return mainUIComponentAllowed(user.MainUI, namespace, uid)
+}
+
+// mainUIRedirect sends the user to their Main UI entry page, when opening
+// the Main UI itself or a page they may not see.
+//
+// Main UI navigates between pages in the browser, so only full page loads
+// pass the router; the filtered page listing covers the rest.
+func mainUIRedirect(req *http.Request, user *config.User) (string, bool) {
+	if user.MainUI == nil || user.MainUI.Entrypoint == "" || req.Method != http.MethodGet {
+		return "", false
+	}
+	entrypoint := "/page/" + user.MainUI.Entrypoint
+
+	if req.URL.Path == "/" {
+		return entrypoint, true
+	}
+	if uid := strings.TrimPrefix(req.URL.Path, "/page/"); uid != req.URL.Path && !user.MainUI.PageAllowed(strings.Trim(uid, "/")) {
+		return entrypoint, true
+	}
+	return "", false
+}
+
+// filterMainUI removes the pages and widgets the user may not see from the component listings
+func (r *Router) filterMainUI(resp *http.Response) error {
+	conf := r.CurrentConfig()
+	user, ok := conf.Users[requestUser(resp.Request)]
+	if conf.Passthrough || !ok || user.MainUI == nil || resp.StatusCode != http.StatusOK ||
+		!strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+	namespace, uid, ok := mainUIComponent(requestURL(resp.Request).Path)
+	if !ok || uid != "" {
+		return nil
+	}
+
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Body = streamFilteredArray(resp.Body, func(component map[string]interface{}) bool {
+		uid, _ := component["uid"].(string)
+		return mainUIComponentAllowed(user.MainUI, namespace, uid)
+	})
+	return nil
+}
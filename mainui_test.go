@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func mainUIRouter(t *testing.T) (*http.ServeMux, func()) {
+	_, mux, closeRemote := testRouter(t, &config.Main{
+		OpenHABVersion: "3",
+		Users: map[string]*config.User{
+			"kid": {
+				Entrypoint: "/",
+				Sitemaps:   config.Sitemap{Default: "demo", Allowed: []string{"demo"}},
+				MainUI: &config.MainUI{
+					Entrypoint: "kids",
+					Pages:      []string{"overview"},
+					Widgets:    []string{"thermostat"},
+				},
+			},
+		},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/rest/ui/components/ui:page":
+			_, _ = w.Write([]byte(`[{"uid": "overview"}, {"uid": "kids"}, {"uid": "energy"}]`))
+		case "/rest/ui/components/ui:widget":
+			_, _ = w.Write([]byte(`[{"uid": "thermostat"}, {"uid": "camera"}]`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	})
+	return mux, closeRemote
+}
+
+func TestMainUIComponentsAreFiltered(t *testing.T) {
+	mux, closeRemote := mainUIRouter(t)
+	defer closeRemote()
+
+	tests := []struct {
+		uri      string
+		code     int
+		expected string
+	}{
+		{uri: "/rest/ui/components/ui:page", code: http.StatusOK, expected: `[{"uid": "overview"}, {"uid": "kids"}]`},
+		{uri: "/rest/ui/components/ui:widget", code: http.StatusOK, expected: `[{"uid": "thermostat"}]`},
+		{uri: "/rest/ui/components/ui:page/kids", code: http.StatusOK, expected: `{}`},
+		{uri: "/rest/ui/components/ui:page/energy", code: http.StatusForbidden},
+		{uri: "/rest/ui/components/ui:widget/camera", code: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, makeGETRequest(tt.uri, "kid"))
+			assert.Equal(t, tt.code, rr.Code)
+			if tt.expected != "" {
+				assert.JSONEq(t, tt.expected, rr.Body.String())
+			}
+		})
+	}
+}
+
+func Test_mainUIRedirect(t *testing.T) {
+	user := &config.User{MainUI: &config.MainUI{Entrypoint: "kids", Pages: []string{"overview"}}}
+
+	tests := []struct {
+		uri      string
+		location string
+		ok       bool
+	}{
+		{uri: "/", location: "/page/kids", ok: true},
+		{uri: "/page/energy", location: "/page/kids", ok: true},
+		{uri: "/page/overview", ok: false},
+		{uri: "/page/kids", ok: false},
+		{uri: "/settings", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			location, ok := mainUIRedirect(makeGETRequest(tt.uri, "kid"), user)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.location, location)
+		})
+	}
+}
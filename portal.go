@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/rs/zerolog/log"
+)
+
+// portalTimeout limits the time to look up the sitemaps of users allowed to open all of them
+const portalTimeout = 5 * time.Second
+
+// defaultPortalPage is rendered, unless `portal.template` overrides it
+const defaultPortalPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>openHAB</title>
+  <style>
+    body { font-family: sans-serif; margin: 3em auto; max-width: 36em; padding: 0 1em; color: #333; }
+    h1 { font-weight: normal; }
+    a { color: #e64a19; }
+  </style>
+</head>
+<body>
+  <h1>Welcome, {{.User}}</h1>
+  {{if .Sitemaps}}<h2>Sitemaps</h2>
+  <ul>
+    {{range .Sitemaps}}<li><a href="{{.URL}}">{{.Label}}</a></li>
+    {{end}}
+  </ul>{{end}}
+  {{if .UIs}}<h2>Interfaces</h2>
+  <ul>
+    {{range .UIs}}<li><a href="{{.URL}}">{{.Label}}</a></li>
+    {{end}}
+  </ul>{{end}}
+  {{if .LogoutURL}}<p><a href="{{.LogoutURL}}">Log out</a></p>{{end}}
+</body>
+</html>
+`
+
+// PortalLink is an entry of the portal page
+type PortalLink struct {
+	Name  string
+	Label string
+	URL   string
+}
+
+// PortalData is available to the template of the portal page
+type PortalData struct {
+	User      string
+	BasePath  string
+	LogoutURL string
+	Sitemaps  []PortalLink
+	UIs       []PortalLink
+}
+
+// portalUIs are the interfaces of openHAB linked on the portal, if the user's paths allow them
+var (
+	portalUIsV2 = []PortalLink{
+		{Name: "dashboard", Label: "Dashboard", URL: "/start/index"},
+		{Name: "basicui", Label: "Basic UI", URL: "/basicui/app"},
+		{Name: "paperui", Label: "Paper UI", URL: "/paperui/index.html"},
+		{Name: "habpanel", Label: "HABPanel", URL: "/habpanel/index.html"},
+		{Name: "habmin", Label: "HABmin", URL: "/habmin/index.html"},
+	}
+	portalUIsV3 = []PortalLink{
+		{Name: "mainui", Label: "Main UI", URL: "/page/overview"},
+		{Name: "basicui", Label: "Basic UI", URL: "/basicui/app"},
+		{Name: "habpanel", Label: "HABPanel", URL: "/habpanel/index.html"},
+	}
+)
+
+// MakePortal parses the template of the portal page; without a portal, it returns nil
+func MakePortal(portal *config.Portal) (*template.Template, error) {
+	if portal == nil {
+		return nil, nil
+	}
+	page := defaultPortalPage
+	if portal.Template != "" {
+		data, err := ioutil.ReadFile(portal.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read portal template: %s", err)
+		}
+		page = string(data)
+	}
+	return template.New("portal").Parse(page)
+}
+
+// isPortalRequest checks whether the request opens the portal page
+func isPortalRequest(req *http.Request, conf *config.Main) bool {
+	if conf.Passthrough || conf.Portal == nil || req.Method != http.MethodGet {
+		return false
+	}
+	path := conf.Portal.Path
+	if path == "" {
+		path = "/"
+	}
+	return req.URL.Path == path
+}
+
+// servePortal renders the portal page for known users; it reports whether the request was handled
+func (r *Router) servePortal(w http.ResponseWriter, req *http.Request, tmpl *template.Template) bool {
+	conf := r.CurrentConfig()
+	if tmpl == nil || !isPortalRequest(req, conf) {
+		return false
+	}
+	name := req.Header.Get("X-Forwarded-Username")
+	user, ok := conf.Users[name]
+	if !ok {
+		return false
+	}
+	if met, _ := conditionsMet(user.Conditions, req, conf); !met {
+		return false
+	}
+
+	data := &PortalData{User: name, BasePath: conf.BasePath, LogoutURL: conf.Portal.LogoutURL}
+	for _, sitemap := range r.portalSitemaps(req, user) {
+		sitemap.URL = conf.BasePath + "/basicui/app?sitemap=" + sitemap.Name
+		data.Sitemaps = append(data.Sitemaps, sitemap)
+	}
+
+	uis := portalUIsV2
+	if conf.OpenHABVersion == "3" || conf.OpenHABVersion == "4" {
+		uis = portalUIsV3
+	}
+	for _, ui := range uis {
+		if ui.Name == "mainui" && user.MainUI != nil && user.MainUI.Entrypoint != "" {
+			ui.URL = "/page/" + user.MainUI.Entrypoint
+		}
+		if !pathAllowed(user, ui.URL, req, conf) {
+			continue
+		}
+		ui.URL = conf.BasePath + ui.URL
+		data.UIs = append(data.UIs, ui)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Error().Err(err).Str("user", name).Msg("failed to render portal")
+	}
+	return true
+}
+
+// portalSitemaps lists the sitemaps the user can open; `*` is resolved by asking openHAB
+func (r *Router) portalSitemaps(req *http.Request, user *config.User) []PortalLink {
+	conf := r.CurrentConfig()
+	names := append([]string{user.Sitemaps.Default}, user.Sitemaps.Allowed...)
+	labels := map[string]string{}
+
+	if len(user.Sitemaps.Allowed) == 1 && user.Sitemaps.Allowed[0] == "*" {
+		sitemaps, err := r.fetchSitemaps(req, user)
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to list sitemaps for the portal")
+		}
+		names = []string{user.Sitemaps.Default}
+		for _, sitemap := range sitemaps {
+			names = append(names, sitemap.Name)
+			labels[sitemap.Name] = sitemap.Label
+		}
+	}
+
+	var links []PortalLink
+	seen := map[string]bool{}
+	for _, name := range names {
+		if name == "" || name == "*" || name == "_default" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if name != user.Sitemaps.Default && !sitemapAllowed(user, name, req, conf) {
+			continue
+		}
+		label := labels[name]
+		if label == "" {
+			label = name
+		}
+		links = append(links, PortalLink{Name: name, Label: label})
+	}
+	return links
+}
+
+func (r *Router) fetchSitemaps(req *http.Request, user *config.User) ([]PortalLink, error) {
+	var sitemaps []PortalLink
+	if err := r.fetchUpstream(req, user, "/rest/sitemaps", &sitemaps); err != nil {
+		return nil, err
+	}
+	return sitemaps, nil
+}
+
+// fetchUpstream reads JSON from the backend the user is proxied to, with the upstream credentials of the user
+func (r *Router) fetchUpstream(req *http.Request, user *config.User, path string, v interface{}) error {
+	conf := r.CurrentConfig()
+	pool, ok := r.Upstreams[upstreamName(req, conf)]
+	if !ok {
+		return fmt.Errorf("unknown upstream")
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), portalTimeout)
+	defer cancel()
+	backend := pool.Select(requestUser(req))
+	upstreamReq, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(backend.URL.String(), "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	upstreamReq = upstreamReq.WithContext(ctx)
+	credentials := conf.UpstreamAuth
+	if user.UpstreamAuth != nil {
+		credentials = user.UpstreamAuth
+	}
+	if credentials != nil {
+		upstreamReq.Header.Set("Authorization", credentials.Authorization())
+	}
+
+	resp, err := r.transport.RoundTrip(upstreamReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// pathAllowed applies the path rules of the user like ruleDirector does
+func pathAllowed(user *config.User, path string, req *http.Request, conf *config.Main) bool {
+	for pathPart, pathConfig := range user.Paths {
+		if !strings.Contains(path, pathPart) {
+			continue
+		}
+		if !pathConfig.Allowed {
+			return false
+		}
+		if met, _ := conditionsMet(pathConfig.Conditions, req, conf); !met {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func portalRouter(t *testing.T, portal *config.Portal) (*http.ServeMux, *[]string, func()) {
+	var requested []string
+	_, mux, closeRemote := testRouter(t, &config.Main{
+		Portal: portal,
+		Users: map[string]*config.User{
+			"admin": {
+				Entrypoint: "/start/index",
+				Sitemaps:   config.Sitemap{Default: "admin", Allowed: []string{"*"}},
+			},
+			"demo": {
+				Entrypoint: "/basicui/app",
+				Sitemaps:   config.Sitemap{Default: "demo", Allowed: []string{"demo", "widgetoverview"}},
+				Paths: map[string]*config.Path{
+					"/paperui": {Allowed: false},
+					"/habmin":  {Allowed: false},
+				},
+			},
+		},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"name": "admin", "label": "Administration"}, {"name": "demo", "label": "Demo House"}]`))
+	})
+	return mux, &requested, closeRemote
+}
+
+func TestPortalListsWhatTheUserCanOpen(t *testing.T) {
+	mux, _, closeRemote := portalRouter(t, &config.Portal{LogoutURL: "/oauth2/sign_out"})
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/", "demo"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "Welcome, demo")
+	assert.Contains(t, body, `<a href="/basicui/app?sitemap=demo">demo</a>`)
+	assert.Contains(t, body, `<a href="/basicui/app?sitemap=widgetoverview">widgetoverview</a>`)
+	assert.Contains(t, body, `<a href="/habpanel/index.html">HABPanel</a>`)
+	assert.NotContains(t, body, "Paper UI")
+	assert.NotContains(t, body, "HABmin")
+	assert.Contains(t, body, `<a href="/oauth2/sign_out">Log out</a>`)
+}
+
+func TestPortalResolvesAllSitemaps(t *testing.T) {
+	mux, requested, closeRemote := portalRouter(t, &config.Portal{})
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/", "admin"))
+	assert.Equal(t, []string{"/rest/sitemaps"}, *requested)
+	assert.Contains(t, rr.Body.String(), `<a href="/basicui/app?sitemap=admin">Administration</a>`)
+	assert.Contains(t, rr.Body.String(), `<a href="/basicui/app?sitemap=demo">Demo House</a>`)
+	assert.NotContains(t, rr.Body.String(), "Log out")
+}
+
+func TestPortalOnOwnPath(t *testing.T) {
+	mux, requested, closeRemote := portalRouter(t, &config.Portal{Path: "/portal"})
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/portal", "demo"))
+	assert.Contains(t, rr.Body.String(), "Welcome, demo")
+	assert.Empty(t, *requested)
+
+	// the root is proxied as before
+	mux.ServeHTTP(httptest.NewRecorder(), makeGETRequest("/", "demo"))
+	assert.Equal(t, []string{"/basicui/app"}, *requested)
+}
+
+func TestPortalReadsFromTheBackendOfTheUser(t *testing.T) {
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"name": "demo", "label": "Demo House"}]`))
+	}))
+	defer standby.Close()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	primary.Close()
+
+	router, err := NewRouter(&Options{Target: primary.URL + "," + standby.URL}, validConfig(t, &config.Main{
+		Portal: &config.Portal{},
+		Users: map[string]*config.User{
+			"admin": {Entrypoint: "/start/index", Sitemaps: config.Sitemap{Default: "admin", Allowed: []string{"*"}}},
+		},
+	}))
+	assert.NoError(t, err)
+	mux := router.MakeMux(router.MakeProxy())
+	pool := router.Upstreams[config.DefaultUpstream]
+
+	// the unreachable backend is ejected like on proxied requests
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/", "admin"))
+	assert.NotContains(t, rr.Body.String(), "Demo House")
+	assert.False(t, pool.Backends[0].Available())
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/", "admin"))
+	assert.Contains(t, rr.Body.String(), `<a href="/basicui/app?sitemap=demo">Demo House</a>`)
+	assert.Equal(t, pool.Backends[1], pool.sticky["admin"])
+}
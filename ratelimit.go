@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Limiter keeps the in-memory token buckets of the configured rate limits
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limit names the bucket a rate limit applies to
+type Limit struct {
+	key string
+	*config.RateLimit
+}
+
+// NewLimiter creates a limiter without any buckets; they are created on first use
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: map[string]*bucket{}}
+}
+
+// Take a token out of every given bucket; if one of them is empty,
+// no token is taken and the time until it refills is returned
+func (l *Limiter) Take(limits []Limit) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t := now()
+	var wait time.Duration
+	for _, lim := range limits {
+		b := l.refill(lim, t)
+		if b.tokens < 1 {
+			missing := time.Duration((1 - b.tokens) / lim.Rate * float64(time.Second))
+			if missing > wait {
+				wait = missing
+			}
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, lim := range limits {
+		l.buckets[lim.key].tokens--
+	}
+	return true, 0
+}
+
+func (l *Limiter) refill(lim Limit, t time.Time) *bucket {
+	burst := float64(lim.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	b, ok := l.buckets[lim.key]
+	if !ok {
+		b = &bucket{tokens: burst, last: t}
+		l.buckets[lim.key] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+t.Sub(b.last).Seconds()*lim.Rate)
+	b.last = t
+	return b
+}
+
+// rateLimits collects the rate limits which apply to the request
+func rateLimits(req *http.Request, conf *config.Main) []Limit {
+	var limits []Limit
+	if conf.RateLimits.Global != nil {
+		limits = append(limits, Limit{key: "global", RateLimit: conf.RateLimits.Global})
+	}
+
+	// unknown users share a bucket, so they cannot create buckets at will
+	user := req.Header.Get("X-Forwarded-Username")
+	userConfig, ok := conf.Users[user]
+	if !ok {
+		user = ""
+	}
+
+	userLimit := conf.RateLimits.User
+	if ok && userConfig.RateLimit != nil {
+		userLimit = userConfig.RateLimit
+	}
+	if userLimit != nil {
+		limits = append(limits, Limit{key: "user:" + user, RateLimit: userLimit})
+	}
+
+	for path, pathLimit := range conf.RateLimits.Paths {
+		if strings.HasPrefix(req.URL.Path, path) {
+			limits = append(limits, Limit{key: "path:" + path + ":" + user, RateLimit: pathLimit})
+		}
+	}
+
+	return limits
+}
+
+// rateLimited answers the request with HTTP 429 when it exceeds a rate limit
+func (r *Router) rateLimited(w http.ResponseWriter, req *http.Request) bool {
+	conf := r.CurrentConfig()
+	limits := rateLimits(req, conf)
+	if len(limits) == 0 {
+		return false
+	}
+
+	ok, wait := r.Limiter.Take(limits)
+	if ok {
+		return false
+	}
+
+	log.Debug().Str("user", req.Header.Get("X-Forwarded-Username")).Str("uri", req.URL.RequestURI()).Dur("retry_after", wait).Msg("rate limit exceeded")
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+	r.ErrorPages.Write(w, req, http.StatusTooManyRequests, "Too many requests, please retry later.", nil)
+	return true
+}
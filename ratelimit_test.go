@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterRefillsOverTime(t *testing.T) {
+	clock := time.Date(2020, 3, 3, 9, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	limiter := NewLimiter()
+	limits := []Limit{{key: "test", RateLimit: &config.RateLimit{Rate: 0.5, Burst: 2}}}
+
+	ok, _ := limiter.Take(limits)
+	assert.True(t, ok)
+	ok, _ = limiter.Take(limits)
+	assert.True(t, ok)
+
+	ok, wait := limiter.Take(limits)
+	assert.False(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+
+	clock = clock.Add(time.Second)
+	ok, wait = limiter.Take(limits)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	clock = clock.Add(time.Second)
+	ok, _ = limiter.Take(limits)
+	assert.True(t, ok)
+}
+
+func TestLimiterTakesNothingWhenOneBucketIsEmpty(t *testing.T) {
+	defer setClock(tuesdayMorning)()
+
+	limiter := NewLimiter()
+	global := Limit{key: "global", RateLimit: &config.RateLimit{Rate: 1, Burst: 5}}
+	user := Limit{key: "user:test", RateLimit: &config.RateLimit{Rate: 1, Burst: 1}}
+
+	ok, _ := limiter.Take([]Limit{global, user})
+	assert.True(t, ok)
+	ok, _ = limiter.Take([]Limit{global, user})
+	assert.False(t, ok)
+
+	assert.Equal(t, 4.0, limiter.buckets["global"].tokens)
+}
+
+func TestRateLimitedRequestIsRejected(t *testing.T) {
+	defer setClock(tuesdayMorning)()
+
+	router := Router{
+		config: &config.Main{
+			Users: map[string]*config.User{
+				"tablet": {},
+				"test":   {},
+			},
+			RateLimits: config.RateLimits{
+				Paths: map[string]*config.RateLimit{
+					"/rest/items": {Rate: 0.1, Burst: 1},
+				},
+			},
+		},
+		Limiter: NewLimiter(),
+	}
+
+	tests := []struct {
+		name       string
+		req        *http.Request
+		limited    bool
+		retryAfter string
+	}{
+		{name: "first request passes", req: makeGETRequest("/rest/items", "tablet"), limited: false},
+		{name: "second request is limited", req: makeGETRequest("/rest/items", "tablet"), limited: true, retryAfter: "10"},
+		{name: "other paths are not limited", req: makeGETRequest("/basicui/app", "tablet"), limited: false},
+		{name: "other users are not limited", req: makeGETRequest("/rest/items", "test"), limited: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			assert.Equal(t, tt.limited, router.rateLimited(rr, tt.req))
+			if tt.limited {
+				assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+				assert.Equal(t, tt.retryAfter, rr.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
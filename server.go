@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultDialTimeout       = 10 * time.Second
+	defaultFlushInterval     = 100 * time.Millisecond
+)
+
+// MakeServer creates the server for the handler.
+//
+// `ReadTimeout` and `WriteTimeout` of the server are left unset, as they
+// would cut off event streams; `request_timeout` limits other requests.
+func (r *Router) MakeServer(addr string, handler http.Handler) *http.Server {
+	conf := r.CurrentConfig().Server
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		IdleTimeout:       defaultIdleTimeout,
+		MaxHeaderBytes:    conf.MaxHeaderBytes,
+	}
+	if conf.ReadHeaderTimeout > 0 {
+		server.ReadHeaderTimeout = conf.ReadHeaderTimeout
+	}
+	if conf.IdleTimeout > 0 {
+		server.IdleTimeout = conf.IdleTimeout
+	}
+	return server
+}
+
+// MakeTransport creates the transport used for requests to openHAB
+func (r *Router) MakeTransport() *http.Transport {
+	conf := r.CurrentConfig().Transport
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	dialTimeout := defaultDialTimeout
+	if conf.DialTimeout > 0 {
+		dialTimeout = conf.DialTimeout
+	}
+	transport.DialContext = dialBackend(&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second})
+	transport.Proxy = proxyBackend
+
+	if conf.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = conf.ResponseHeaderTimeout
+	}
+	if conf.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = conf.IdleConnTimeout
+	}
+	if conf.MaxIdleConns > 0 {
+		transport.MaxIdleConns = conf.MaxIdleConns
+	}
+	if conf.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = conf.MaxIdleConnsPerHost
+	}
+	return transport
+}
+
+// flushInterval of the proxy; event streams are flushed immediately regardless
+func flushInterval(conf *config.Main) time.Duration {
+	if conf.Transport.FlushInterval > 0 {
+		return conf.Transport.FlushInterval
+	}
+	return defaultFlushInterval
+}
+
+// withRequestTimeout limits the time a request may take
+func withRequestTimeout(req *http.Request, conf *config.Main) (*http.Request, context.CancelFunc) {
+	if conf.Server.RequestTimeout <= 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), conf.Server.RequestTimeout)
+	return req.WithContext(ctx), cancel
+}
+
+// bodyLimit returns the limit of the longest matching path in `body_limits`, 0 without one
+func bodyLimit(req *http.Request, conf *config.Main) int64 {
+	match := ""
+	for path := range conf.BodyLimits {
+		if strings.HasPrefix(req.URL.Path, path) && len(path) > len(match) {
+			match = path
+		}
+	}
+	if match == "" {
+		return 0
+	}
+	return conf.BodyLimits[match]
+}
+
+// bodyTooLarge rejects requests exceeding their body limit with HTTP 413;
+// bodies of unknown length are cut off at the limit while they are forwarded
+func bodyTooLarge(w http.ResponseWriter, req *http.Request, pages *ErrorPages, conf *config.Main) bool {
+	limit := bodyLimit(req, conf)
+	if limit == 0 {
+		return false
+	}
+
+	if req.ContentLength > limit {
+		log.Debug().Str("uri", req.URL.RequestURI()).Int64("content_length", req.ContentLength).Int64("limit", limit).Msg("request body too large")
+		pages.Write(w, req, http.StatusRequestEntityTooLarge, fmt.Sprintf("The request body exceeds the limit of %d bytes.", limit), nil)
+		return true
+	}
+	if req.Body != nil {
+		req.Body = http.MaxBytesReader(w, req.Body, limit)
+	}
+	return false
+}
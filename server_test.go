@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_bodyLimit(t *testing.T) {
+	conf := &config.Main{BodyLimits: map[string]int64{
+		"/rest":       1024,
+		"/rest/items": 64,
+	}}
+
+	tests := []struct {
+		uri      string
+		expected int64
+	}{
+		{uri: "/rest/items/Light", expected: 64},
+		{uri: "/rest/sitemaps", expected: 1024},
+		{uri: "/basicui/CMD", expected: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			assert.Equal(t, tt.expected, bodyLimit(makeGETRequest(tt.uri, ""), conf))
+		})
+	}
+}
+
+func TestServerAndTransportAreConfigured(t *testing.T) {
+	router := &Router{config: &config.Main{
+		Server:    config.Server{IdleTimeout: time.Minute, MaxHeaderBytes: 8192},
+		Transport: config.Transport{ResponseHeaderTimeout: 20 * time.Second, MaxIdleConnsPerHost: 4},
+	}}
+
+	server := router.MakeServer(":8080", nil)
+	assert.Equal(t, defaultReadHeaderTimeout, server.ReadHeaderTimeout)
+	assert.Equal(t, time.Minute, server.IdleTimeout)
+	assert.Equal(t, 8192, server.MaxHeaderBytes)
+	assert.Equal(t, time.Duration(0), server.WriteTimeout)
+
+	transport := router.MakeTransport()
+	assert.Equal(t, 20*time.Second, transport.ResponseHeaderTimeout)
+	assert.Equal(t, 4, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, defaultFlushInterval, flushInterval(router.CurrentConfig()))
+}
+
+func TestRequestLimits(t *testing.T) {
+	_, mux, closeRemote := testRouter(t, &config.Main{
+		Passthrough: true,
+		Server:      config.Server{RequestTimeout: 50 * time.Millisecond},
+		BodyLimits:  map[string]int64{"/rest/items": 4},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}
+	})
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeRequest(http.MethodPost, "/rest/items/Light", "text/plain", "TOGGLE"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeRequest(http.MethodPost, "/rest/items/Light", "text/plain", "ON"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/slow", ""))
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+
+	// event streams are exempt from the request timeout
+	req := makeGETRequest("/slow", "")
+	req.Header.Set("Accept", "text/event-stream")
+	rr = httptest.NewRecorder()
+	start := time.Now()
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, time.Since(start) >= time.Second, strings.TrimSpace(rr.Body.String()))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// drainState tracks a shutdown in progress; the zero value is ready to use
+type drainState struct {
+	draining int32
+	once     sync.Once
+	streams  chan struct{}
+	closed   sync.Once
+}
+
+func (d *drainState) start() {
+	atomic.StoreInt32(&d.draining, 1)
+}
+
+func (d *drainState) isDraining() bool {
+	return atomic.LoadInt32(&d.draining) == 1
+}
+
+// streamsClosed is closed once long-lived streams have to end
+func (d *drainState) streamsClosed() <-chan struct{} {
+	d.once.Do(func() { d.streams = make(chan struct{}) })
+	return d.streams
+}
+
+func (d *drainState) closeStreams() {
+	d.streamsClosed()
+	d.closed.Do(func() { close(d.streams) })
+}
+
+// isStream checks whether the request opens a connection which does not end on its own,
+// i.e. server-sent events like `/rest/events` or WebSockets
+func isStream(req *http.Request) bool {
+	return isWebSocketUpgrade(req) ||
+		strings.Contains(req.Header.Get("Accept"), "text/event-stream") ||
+		strings.HasPrefix(req.URL.Path, "/rest/events") ||
+		strings.HasPrefix(req.URL.Path, "/rest/sitemaps/events/")
+}
+
+// withStreamContext cancels the request, once streams are closed during shutdown
+func (r *Router) withStreamContext(req *http.Request) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(req.Context())
+	go func() {
+		select {
+		case <-r.drain.streamsClosed():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return req.WithContext(ctx), cancel
+}
+
+// Shutdown drains the router: readiness fails first, so load balancers stop
+// sending new requests, then the listener is closed, streams are ended and
+// in-flight requests are awaited up to the shutdown timeout
+func (r *Router) Shutdown(server *http.Server, stop chan struct{}) error {
+	log.Info().Dur("drain_period", r.Opts.DrainPeriod).Msg("draining")
+	r.drain.start()
+	server.SetKeepAlivesEnabled(false)
+	time.Sleep(r.Opts.DrainPeriod)
+
+	close(stop)
+	server.RegisterOnShutdown(r.drain.closeStreams)
+
+	ctx := context.Background()
+	if r.Opts.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Opts.ShutdownTimeout)
+		defer cancel()
+	}
+	log.Info().Msg("shutting down")
+	return server.Shutdown(ctx)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessFailsWhileDraining(t *testing.T) {
+	router, mux, closeRemote := testRouter(t, &config.Main{Passthrough: true}, func(w http.ResponseWriter, r *http.Request) {})
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/readiness", ""))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	router.drain.start()
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/readiness", ""))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+func TestShutdownEndsEventStreams(t *testing.T) {
+	remoteServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: message\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer remoteServer.Close()
+
+	router, err := NewRouter(&Options{Target: remoteServer.URL, ShutdownTimeout: 5 * time.Second}, validConfig(t, &config.Main{Passthrough: true}))
+	assert.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := &http.Server{Handler: router.MakeMux(router.MakeProxy())}
+	go func() { _ = server.Serve(listener) }()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/rest/events")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "event: message\n", line)
+
+	start := time.Now()
+	assert.NoError(t, router.Shutdown(server, make(chan struct{})))
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	_, err = http.Get("http://" + listener.Addr().String() + "/rest/events")
+	assert.Error(t, err)
+}
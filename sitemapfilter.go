@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/rs/zerolog/log"
+)
+
+// sitemapEventsPath is where clients subscribe to the state changes of a sitemap page
+const sitemapEventsPath = "/rest/sitemaps/events/"
+
+// pageCacheTTL is how long the pages hidden from a user are kept before the
+// sitemap is read again, so changes of the sitemap apply after this period
+const pageCacheTTL = time.Minute
+
+// PageFilter determines the sitemap pages hidden from each user from the
+// definition of the sitemap, so hidden pages cannot be opened directly
+type PageFilter struct {
+	mu     sync.Mutex
+	hidden map[string]*hiddenPages
+}
+
+// hiddenPages are the pages of a sitemap linked from widgets hidden from a user
+type hiddenPages struct {
+	pages   map[string]bool
+	conf    *config.Main
+	expires time.Time
+}
+
+// NewPageFilter creates a page filter, which has not read any sitemap yet
+func NewPageFilter() *PageFilter {
+	return &PageFilter{hidden: map[string]*hiddenPages{}}
+}
+
+// Hidden reports whether the page, or a page it is nested in, is hidden from the user;
+// definition reads the sitemap, unless its hidden pages are known for the config
+func (f *PageFilter) Hidden(conf *config.Main, user string, sitemap string, page string, definition func() (interface{}, error)) (bool, error) {
+	userConfig := conf.Users[user]
+	for _, hidden := range userConfig.Sitemaps.Hidden.Pages {
+		if strings.HasPrefix(page, hidden) {
+			return true, nil
+		}
+	}
+
+	key := user + "/" + sitemap
+	f.mu.Lock()
+	known, ok := f.hidden[key]
+	f.mu.Unlock()
+	if !ok || known.conf != conf || !now().Before(known.expires) {
+		document, err := definition()
+		if err != nil {
+			return false, err
+		}
+		known = &hiddenPages{pages: map[string]bool{}, conf: conf, expires: now().Add(pageCacheTTL)}
+		collectHiddenPages(document, userConfig, false, known.pages)
+		f.mu.Lock()
+		f.hidden[key] = known
+		f.mu.Unlock()
+	}
+
+	for hidden := range known.pages {
+		if strings.HasPrefix(page, hidden) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// collectHiddenPages walks the widgets of a sitemap and collects the pages
+// linked from hidden widgets and from anything nested in them
+func collectHiddenPages(node interface{}, user *config.User, hidden bool, pages map[string]bool) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if hidden {
+			if page := linkedPage(value); page != "" {
+				pages[page] = true
+			}
+		}
+		for key, child := range value {
+			if widgets, ok := child.([]interface{}); ok && key == "widgets" {
+				for _, widget := range widgets {
+					w, ok := widget.(map[string]interface{})
+					collectHiddenPages(widget, user, hidden || ok && widgetHidden(w, user), pages)
+				}
+				continue
+			}
+			collectHiddenPages(child, user, hidden, pages)
+		}
+	case []interface{}:
+		for _, child := range value {
+			collectHiddenPages(child, user, hidden, pages)
+		}
+	}
+}
+
+// sitemapPage splits a path like `/rest/sitemaps/<sitemap>/<page>`;
+// the page is empty for the sitemap itself
+func sitemapPage(path string) (sitemap string, page string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 3 || len(parts) > 4 || parts[0] != "rest" || parts[1] != "sitemaps" || parts[2] == "events" {
+		return "", "", false
+	}
+	if len(parts) == 4 {
+		page = parts[3]
+	}
+	return parts[2], page, true
+}
+
+// pageDirector sends requests for hidden pages to the homepage of the sitemap
+func (r *Router) pageDirector(req *http.Request) {
+	conf := r.CurrentConfig()
+	user := req.Header.Get("X-Forwarded-Username")
+	if _, ok := conf.Users[user]; conf.Passthrough || !ok {
+		return
+	}
+
+	if sitemap, page, ok := sitemapPage(req.URL.Path); ok && page != "" && page != sitemap {
+		if r.pageHidden(req, conf, user, sitemap, page) {
+			log.Debug().Str("user", user).Str("sitemap", sitemap).Str("page", page).Msg("redirecting to homepage - denying access to hidden page")
+			req.URL.Path = "/rest/sitemaps/" + sitemap + "/" + sitemap
+		}
+		return
+	}
+
+	if strings.HasPrefix(req.URL.Path, "/basicui/app") || strings.HasPrefix(req.URL.Path, sitemapEventsPath) {
+		query := req.URL.Query()
+		parameter := "w"
+		if strings.HasPrefix(req.URL.Path, sitemapEventsPath) {
+			parameter = "pageid"
+		}
+		sitemap, page := query.Get("sitemap"), query.Get(parameter)
+		if page != "" && page != sitemap && r.pageHidden(req, conf, user, sitemap, page) {
+			log.Debug().Str("user", user).Str("sitemap", sitemap).Str("page", page).Msg("redirecting to homepage - denying access to hidden page")
+			if parameter == "w" {
+				query.Del(parameter)
+			} else {
+				query.Set(parameter, sitemap)
+			}
+			req.URL.RawQuery = query.Encode()
+		}
+	}
+}
+
+// pageHidden checks the page against the sitemap as openHAB serves it to the user;
+// when the sitemap cannot be read, the page is treated as hidden
+func (r *Router) pageHidden(req *http.Request, conf *config.Main, user string, sitemap string, page string) bool {
+	hidden, err := r.Pages.Hidden(conf, user, sitemap, page, func() (interface{}, error) {
+		var document interface{}
+		err := r.fetchUpstream(req, conf.Users[user], "/rest/sitemaps/"+url.PathEscape(sitemap), &document)
+		return document, err
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("user", user).Str("sitemap", sitemap).Msg("failed to read sitemap, denying access to page")
+		return true
+	}
+	return hidden
+}
+
+// filterSitemap removes widgets from sitemap responses, whose item, label or
+// linked page is hidden from the user
+func (r *Router) filterSitemap(resp *http.Response) error {
+	conf := r.CurrentConfig()
+	user := requestUser(resp.Request)
+	userConfig, ok := conf.Users[user]
+	contentType := resp.Header.Get("Content-Type")
+	if conf.Passthrough || !ok || resp.StatusCode != http.StatusOK ||
+		!strings.Contains(contentType, "application/json") && !strings.Contains(contentType, "text/event-stream") {
+		return nil
+	}
+	if strings.HasPrefix(requestURL(resp.Request).Path, sitemapEventsPath) {
+		resp.Body = streamFilteredEvents(resp.Body, func(event map[string]interface{}) bool {
+			return !widgetHidden(event, userConfig)
+		})
+		return nil
+	}
+	sitemap, _, ok := sitemapPage(requestURL(resp.Request).Path)
+	if !ok || !strings.Contains(contentType, "application/json") {
+		return nil
+	}
+
+	var document interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return fmt.Errorf("failed to decode sitemap '%s': %s", sitemap, err)
+	}
+	_ = resp.Body.Close()
+
+	filter := &widgetFilter{userConfig: userConfig}
+	filter.walk(document)
+
+	body, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	return nil
+}
+
+type widgetFilter struct {
+	userConfig *config.User
+}
+
+func (f *widgetFilter) walk(node interface{}) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if widgets, ok := child.([]interface{}); ok && key == "widgets" {
+				value[key] = f.filter(widgets)
+				continue
+			}
+			f.walk(child)
+		}
+	case []interface{}:
+		for _, child := range value {
+			f.walk(child)
+		}
+	}
+}
+
+func (f *widgetFilter) filter(widgets []interface{}) []interface{} {
+	kept := make([]interface{}, 0, len(widgets))
+	for _, widget := range widgets {
+		if w, ok := widget.(map[string]interface{}); ok && widgetHidden(w, f.userConfig) {
+			continue
+		}
+		f.walk(widget)
+		kept = append(kept, widget)
+	}
+	return kept
+}
+
+// widgetHidden checks the item, the label and the linked page of a widget
+// or a widget event against the config of the user
+func widgetHidden(widget map[string]interface{}, user *config.User) bool {
+	if item, ok := widget["item"].(map[string]interface{}); ok {
+		if name, ok := item["name"].(string); ok && !user.ItemAllowed(name) {
+			return true
+		}
+	}
+
+	if label, ok := widget["label"].(string); ok {
+		// labels carry the formatted state, e.g. `Temperature [21.5 °C]`
+		label = strings.TrimSpace(strings.SplitN(label, "[", 2)[0])
+		for _, hidden := range user.Sitemaps.Hidden.Labels {
+			if label == hidden {
+				return true
+			}
+		}
+	}
+
+	if page := linkedPage(widget); page != "" {
+		for _, hidden := range user.Sitemaps.Hidden.Pages {
+			if strings.HasPrefix(page, hidden) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func linkedPage(widget map[string]interface{}) string {
+	if page, ok := widget["linkedPage"].(map[string]interface{}); ok {
+		if id, ok := page["id"].(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// streamFilteredEvents drops server-sent events, whose data the filter rejects,
+// while they are read; other events and comments are passed on as they are
+func streamFilteredEvents(body io.ReadCloser, filter func(map[string]interface{}) bool) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		defer body.Close()
+		_ = writer.CloseWithError(copyFilteredEvents(writer, body, filter))
+	}()
+	return reader
+}
+
+func copyFilteredEvents(dst io.Writer, src io.Reader, filter func(map[string]interface{}) bool) error {
+	in := bufio.NewReader(src)
+	var event bytes.Buffer
+	var data strings.Builder
+	for {
+		line, err := in.ReadString('\n')
+		event.WriteString(line)
+		if strings.HasPrefix(line, "data:") {
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+
+		// events end with an empty line
+		if strings.TrimRight(line, "\r\n") == "" || err != nil {
+			var widget map[string]interface{}
+			if json.Unmarshal([]byte(data.String()), &widget) != nil || filter(widget) {
+				if _, err := dst.Write(event.Bytes()); err != nil {
+					return err
+				}
+			}
+			event.Reset()
+			data.Reset()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
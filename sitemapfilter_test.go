@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const demoSitemap = `{
+  "id": "demo",
+  "widgets": [
+    {"widgetId": "00", "type": "Frame", "label": "Lights", "widgets": [
+      {"widgetId": "0000", "type": "Switch", "label": "Kids", "item": {"name": "Light_Kids"}},
+      {"widgetId": "0001", "type": "Switch", "label": "Alarm [ON]", "item": {"name": "Alarm"}}
+    ]},
+    {"widgetId": "01", "type": "Frame", "label": "Cameras", "widgets": [
+      {"widgetId": "0100", "type": "Image", "label": "Garden"},
+      {"widgetId": "0101", "type": "Text", "label": "Driveway", "linkedPage": {"id": "0101", "widgets": []}}
+    ]},
+    {"widgetId": "02", "type": "Text", "label": "Garage", "linkedPage": {"id": "02", "widgets": []}}
+  ]
+}`
+
+const demoEvents = `event: event
+data: {"widgetId": "0000", "label": "Kids", "item": {"name": "Light_Kids", "state": "ON"}}
+
+event: event
+data: {"widgetId": "0001", "label": "Alarm [ON]", "item": {"name": "Alarm", "state": "ON"}}
+
+event: event
+data: {"widgetId": "0100", "label": "Cameras"}
+
+: keep-alive
+
+`
+
+func sitemapRouter(t *testing.T) (*Router, *http.ServeMux, *[]string, func()) {
+	var requested []string
+	denied := false
+	router, mux, closeRemote := testRouter(t, &config.Main{
+		Users: map[string]*config.User{
+			"guest": {
+				Entrypoint: "/basicui/app",
+				Sitemaps: config.Sitemap{
+					Default: "demo",
+					Allowed: []string{"demo"},
+					Hidden: config.WidgetFilter{
+						Labels: []string{"Cameras"},
+						Pages:  []string{"02"},
+					},
+				},
+				Items: map[string]*config.Item{
+					"Alarm": {Allowed: &denied},
+				},
+			},
+		},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.RequestURI())
+		if strings.HasPrefix(r.URL.Path, "/rest/sitemaps/events/") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(demoEvents))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(demoSitemap))
+	})
+	return router, mux, &requested, closeRemote
+}
+
+func TestSitemapWidgetsAreFiltered(t *testing.T) {
+	_, mux, _, closeRemote := sitemapRouter(t)
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/sitemaps/demo/demo", "guest"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{
+	  "id": "demo",
+	  "widgets": [
+	    {"widgetId": "00", "type": "Frame", "label": "Lights", "widgets": [
+	      {"widgetId": "0000", "type": "Switch", "label": "Kids", "item": {"name": "Light_Kids"}}
+	    ]}
+	  ]
+	}`, rr.Body.String())
+}
+
+func TestHiddenPagesCannotBeOpened(t *testing.T) {
+	router, mux, requested, closeRemote := sitemapRouter(t)
+	defer closeRemote()
+	router.CurrentConfig().Users["guest"].Sitemaps.Hidden.Labels = []string{"Garage", "Cameras"}
+	router.CurrentConfig().Users["guest"].Sitemaps.Hidden.Pages = nil
+
+	// the pages are hidden without the user loading the pages linking them first
+	mux.ServeHTTP(httptest.NewRecorder(), makeGETRequest("/rest/sitemaps/demo/02", "guest"))
+	mux.ServeHTTP(httptest.NewRecorder(), makeGETRequest("/rest/sitemaps/demo/0201", "guest"))
+	mux.ServeHTTP(httptest.NewRecorder(), makeGETRequest("/rest/sitemaps/demo/0101", "guest"))
+	mux.ServeHTTP(httptest.NewRecorder(), makeGETRequest("/basicui/app?sitemap=demo&w=02", "guest"))
+	mux.ServeHTTP(httptest.NewRecorder(), makeGETRequest("/rest/sitemaps/events/1?sitemap=demo&pageid=02", "guest"))
+
+	assert.Equal(t, []string{
+		"/rest/sitemaps/demo",
+		"/rest/sitemaps/demo/demo",
+		"/rest/sitemaps/demo/demo",
+		"/rest/sitemaps/demo/demo",
+		"/basicui/app?sitemap=demo",
+		"/rest/sitemaps/events/1?pageid=demo&sitemap=demo",
+	}, *requested)
+}
+
+func TestHiddenPagesAreDeniedWithoutTheSitemap(t *testing.T) {
+	_, mux, closeRemote := testRouter(t, &config.Main{
+		Users: map[string]*config.User{
+			"guest": {Entrypoint: "/basicui/app", Sitemaps: config.Sitemap{Default: "demo", Allowed: []string{"demo"}}},
+		},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rest/sitemaps/demo" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(r.URL.RequestURI()))
+	})
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/sitemaps/demo/02", "guest"))
+	assert.Equal(t, "/rest/sitemaps/demo/demo", rr.Body.String())
+}
+
+func TestSitemapEventsAreFiltered(t *testing.T) {
+	_, mux, _, closeRemote := sitemapRouter(t)
+	defer closeRemote()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/sitemaps/events/1?sitemap=demo&pageid=demo", "guest"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `event: event
+data: {"widgetId": "0000", "label": "Kids", "item": {"name": "Light_Kids", "state": "ON"}}
+
+: keep-alive
+
+`, rr.Body.String())
+}
+
+func Test_sitemapPage(t *testing.T) {
+	tests := []struct {
+		path    string
+		sitemap string
+		page    string
+		ok      bool
+	}{
+		{path: "/rest/sitemaps/demo", sitemap: "demo", ok: true},
+		{path: "/rest/sitemaps/demo/0100", sitemap: "demo", page: "0100", ok: true},
+		{path: "/rest/sitemaps/events/subscription", ok: false},
+		{path: "/rest/sitemaps", ok: false},
+		{path: "/rest/items/Light", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			sitemap, page, ok := sitemapPage(tt.path)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.sitemap, sitemap)
+			assert.Equal(t, tt.page, page)
+		})
+	}
+}
@@ -12,7 +12,7 @@ docker run --rm \
   -v "$(pwd)/../":/go/src/github.com/hendrikmaus/openhab-auth-router \
   -w /go/src/github.com/hendrikmaus/openhab-auth-router \
   golang:1.13.8-buster \
-  go build -o openhab-auth-router -mod=vendor /go/src/github.com/hendrikmaus/openhab-auth-router/main.go
+  go build -o openhab-auth-router -mod=vendor .
 
 echo "setting permissions"
 mv ../openhab-auth-router . && \
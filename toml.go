@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// decodeTOML reads a TOML document keeping the order of its keys like the YAML
+// documents the config is merged from; dates and times are rejected, as the
+// config does not use them
+func decodeTOML(data []byte) (yaml.MapSlice, error) {
+	var doc map[string]interface{}
+	meta, err := toml.Decode(string(data), &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// the keys of each table in the order they are defined; dotted keys like
+	// `sitemaps.default` define their tables implicitly
+	order := map[string][]string{}
+	seen := map[string]bool{}
+	for _, key := range meta.Keys() {
+		for i := 1; i <= len(key); i++ {
+			path := strings.Join(key[:i], "\x00")
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			parent := strings.Join(key[:i-1], "\x00")
+			order[parent] = append(order[parent], key[i-1])
+		}
+	}
+
+	value, err := tomlValue(doc, nil, order)
+	if err != nil {
+		return nil, err
+	}
+	return value.(yaml.MapSlice), nil
+}
+
+func tomlValue(value interface{}, path []string, order map[string][]string) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := tableKeys(v, order[strings.Join(path, "\x00")])
+		result := make(yaml.MapSlice, 0, len(keys))
+		for _, key := range keys {
+			item, err := tomlValue(v[key], append(append([]string{}, path...), key), order)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, yaml.MapItem{Key: key, Value: item})
+		}
+		return result, nil
+	case []map[string]interface{}:
+		result := make([]interface{}, len(v))
+		for i, table := range v {
+			item, err := tomlValue(table, path, order)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = item
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, element := range v {
+			item, err := tomlValue(element, path, order)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = item
+		}
+		return result, nil
+	case time.Time:
+		return nil, fmt.Errorf("'%s': dates and times are not supported", strings.Join(path, "."))
+	default:
+		return v, nil
+	}
+}
+
+// tableKeys orders the keys of a table as defined; keys of inline tables and
+// arrays, which are not listed, follow sorted by name
+func tableKeys(table map[string]interface{}, defined []string) []string {
+	keys := make([]string, 0, len(table))
+	listed := map[string]bool{}
+	for _, key := range defined {
+		if _, ok := table[key]; ok && !listed[key] {
+			keys = append(keys, key)
+			listed[key] = true
+		}
+	}
+	var rest []string
+	for key := range table {
+		if !listed[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDecodeTOML(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected yaml.MapSlice
+		wantErr  string
+	}{
+		{
+			name: "values",
+			data: `# the router
+passthrough = false
+openhab_version = "3"
+body_limits = { "/rest/items/" = 1_024, '/rest/' = 0x10 }
+ratio = 1.5
+trusted_proxies = [
+  "10.0.0.0/8", # the cluster
+  '192.168.0.0/16',
+]
+`,
+			expected: yaml.MapSlice{
+				{Key: "passthrough", Value: false},
+				{Key: "openhab_version", Value: "3"},
+				{Key: "body_limits", Value: yaml.MapSlice{
+					{Key: "/rest/items/", Value: int64(1024)},
+					{Key: "/rest/", Value: int64(16)},
+				}},
+				{Key: "ratio", Value: 1.5},
+				{Key: "trusted_proxies", Value: []interface{}{"10.0.0.0/8", "192.168.0.0/16"}},
+			},
+		},
+		{
+			name: "tables",
+			data: `[server]
+idle_timeout = "1m"
+
+[users.john]
+entrypoint = "/start/index"
+sitemaps.default = "john"
+
+[[users.john.windows]]
+days = ["mon"]
+
+[[users.john.windows]]
+days = ["tue"]
+
+[users.jane]
+`,
+			expected: yaml.MapSlice{
+				{Key: "server", Value: yaml.MapSlice{{Key: "idle_timeout", Value: "1m"}}},
+				{Key: "users", Value: yaml.MapSlice{
+					{Key: "john", Value: yaml.MapSlice{
+						{Key: "entrypoint", Value: "/start/index"},
+						{Key: "sitemaps", Value: yaml.MapSlice{{Key: "default", Value: "john"}}},
+						{Key: "windows", Value: []interface{}{
+							yaml.MapSlice{{Key: "days", Value: []interface{}{"mon"}}},
+							yaml.MapSlice{{Key: "days", Value: []interface{}{"tue"}}},
+						}},
+					}},
+					{Key: "jane", Value: yaml.MapSlice{}},
+				}},
+			},
+		},
+		{
+			name:    "date",
+			data:    "since = 2020-01-01\n",
+			wantErr: "dates and times are not supported",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := decodeTOML([]byte(tt.data))
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), tt.wantErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, doc)
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+)
+
+// default durations of the health checks
+const (
+	defaultCheckInterval = 10 * time.Second
+	defaultCheckTimeout  = 5 * time.Second
+	defaultEjectFor      = 30 * time.Second
+)
+
+// Backend is one openHAB instance of an upstream
+type Backend struct {
+	URL *url.URL
+
+	mu           sync.Mutex
+	healthy      bool
+	ejectedUntil time.Time
+}
+
+// Available reports whether the backend passed its last health check
+// and is not ejected after a failed request
+func (b *Backend) Available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.healthy && !now().Before(b.ejectedUntil)
+}
+
+func (b *Backend) setHealthy(healthy bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.healthy = healthy
+}
+
+func (b *Backend) eject(period time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ejectedUntil = now().Add(period)
+}
+
+// Pool holds the backends of an upstream in order of preference
+type Pool struct {
+	Name     string
+	Backends []*Backend
+
+	mu     sync.Mutex
+	sticky map[string]*Backend
+}
+
+// NewPool creates a pool of backends, which are considered healthy until checked
+func NewPool(name string, targets []string) (*Pool, error) {
+	pool := &Pool{Name: name, sticky: map[string]*Backend{}}
+	for _, target := range targets {
+		remote, err := parseTarget(strings.TrimSpace(target))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse target '%s' of upstream '%s': %s", target, name, err)
+		}
+		pool.Backends = append(pool.Backends, &Backend{URL: remote, healthy: true})
+	}
+	if len(pool.Backends) == 0 {
+		return nil, fmt.Errorf("upstream '%s' has no backends", name)
+	}
+	return pool, nil
+}
+
+// Current is the first available backend; when none is available, the first one
+func (p *Pool) Current() *Backend {
+	for _, backend := range p.Backends {
+		if backend.Available() {
+			return backend
+		}
+	}
+	return p.Backends[0]
+}
+
+// Select a backend for the given key; as long as the backend selected before
+// is available, it is kept, so long-lived sessions like SSE are not moved.
+// An empty key is not pinned and gets the current backend.
+func (p *Pool) Select(key string) *Backend {
+	if key == "" {
+		return p.Current()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if backend, ok := p.sticky[key]; ok && backend.Available() {
+		return backend
+	}
+
+	backend := p.Current()
+	p.sticky[key] = backend
+	return backend
+}
+
+// MakeUpstreams creates a pool for the `-target` option and all configured upstreams;
+// `-target` may list several backends separated by comma
+func (r *Router) MakeUpstreams() (map[string]*Pool, error) {
+	upstreams := map[string]*Pool{}
+
+	pool, err := NewPool(config.DefaultUpstream, strings.Split(r.Opts.Target, ","))
+	if err != nil {
+		return nil, err
+	}
+	upstreams[config.DefaultUpstream] = pool
+
+	for name, upstream := range r.CurrentConfig().Upstreams {
+		pool, err := NewPool(name, upstream.Targets())
+		if err != nil {
+			return nil, err
+		}
+		upstreams[name] = pool
+	}
+
+	return upstreams, nil
+}
+
+// StartHealthChecks probes every backend periodically until the stop channel is closed
+func (r *Router) StartHealthChecks(stop <-chan struct{}) {
+	interval := r.CurrentConfig().HealthCheck.Interval
+	if interval == 0 {
+		interval = defaultCheckInterval
+	}
+	timeout := r.CurrentConfig().HealthCheck.Timeout
+	if timeout == 0 {
+		timeout = defaultCheckTimeout
+	}
+	client := &http.Client{Timeout: timeout, Transport: r.MakeTransport()}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			r.checkBackends(client)
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (r *Router) checkBackends(client *http.Client) {
+	for _, pool := range r.Upstreams {
+		for _, backend := range pool.Backends {
+			err := probe(client, backend.URL)
+			if err != nil && backend.Available() {
+				r.Log.Warn().Err(err).Str("upstream", pool.Name).Str("backend", backend.URL.String()).Msg("backend failed health check")
+			}
+			backend.setHealthy(err == nil)
+		}
+	}
+}
+
+// readinessClient probes backends on readiness checks, including those behind Unix sockets
+var readinessClient = &http.Client{Transport: &http.Transport{
+	DialContext: dialBackend(&net.Dialer{Timeout: defaultDialTimeout}),
+	Proxy:       proxyBackend,
+}}
+
+// probe asserts access to the REST API of an openHAB instance
+func probe(client *http.Client, remote *url.URL) error {
+	resp, err := client.Get(remote.String() + "/rest/")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ejectingTransport ejects backends, which the request failed to reach
+type ejectingTransport struct {
+	http.RoundTripper
+	router *Router
+}
+
+func (t *ejectingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil && req.Context().Err() == nil {
+		period := t.router.CurrentConfig().HealthCheck.EjectFor
+		if period == 0 {
+			period = defaultEjectFor
+		}
+		for _, pool := range t.router.Upstreams {
+			for _, backend := range pool.Backends {
+				if backend.URL.Host == req.URL.Host {
+					t.router.Log.Warn().Err(err).Str("upstream", pool.Name).Str("backend", backend.URL.String()).Dur("period", period).Msg("ejecting backend")
+					backend.eject(period)
+				}
+			}
+		}
+	}
+	return resp, err
+}
+
+// upstreamName selects the upstream for the request.
+//
+// The longest route matching the path wins over the upstream of the user;
+// without either, the `-target` is used.
+func upstreamName(req *http.Request, conf *config.Main) string {
+	route := ""
+	for path := range conf.Routes {
+		if strings.HasPrefix(req.URL.Path, path) && len(path) > len(route) {
+			route = path
+		}
+	}
+	if route != "" {
+		return conf.Routes[route]
+	}
+
+	if user, ok := conf.Users[req.Header.Get("X-Forwarded-Username")]; ok && user.Upstream != "" {
+		return user.Upstream
+	}
+
+	return config.DefaultUpstream
+}
+
+// credentialsDirector replaces the credentials sent by the client with the
+// upstream credentials of the user or, without those, the default ones.
+// Without any, the credentials of the client are removed, as they would act
+// on openHAB with an identity the router did not check; only passthrough
+// mode keeps them.
+func credentialsDirector(req *http.Request, conf *config.Main) {
+	credentials := conf.UpstreamAuth
+	if user, ok := conf.Users[req.Header.Get("X-Forwarded-Username")]; ok && !conf.Passthrough && user.UpstreamAuth != nil {
+		credentials = user.UpstreamAuth
+	}
+	if credentials == nil {
+		if !conf.Passthrough {
+			req.Header.Del("Authorization")
+		}
+		return
+	}
+
+	req.Header.Set("Authorization", credentials.Authorization())
+}
+
+// targetDirector points the request at the given upstream, just like the
+// director of httputil.NewSingleHostReverseProxy
+func targetDirector(req *http.Request, target *url.URL) {
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path = joinPath(target.Path, req.URL.Path)
+	if target.RawQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = target.RawQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// explicitly disable User-Agent so it's not set to default value
+		req.Header.Set("User-Agent", "")
+	}
+}
+
+func joinPath(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
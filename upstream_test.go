@@ -0,0 +1,292 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_upstreamName(t *testing.T) {
+	conf := &config.Main{
+		Upstreams: map[string]*config.Upstream{
+			"holiday": {Target: "http://holiday:8080"},
+			"panels":  {Target: "http://panels:8080"},
+		},
+		Routes: map[string]string{
+			"/habpanel":        "panels",
+			"/habpanel/static": config.DefaultUpstream,
+		},
+		Users: map[string]*config.User{
+			"caretaker": {Upstream: "holiday"},
+			"test":      {},
+		},
+	}
+	tests := []struct {
+		name     string
+		req      *http.Request
+		expected string
+	}{
+		{name: "user without upstream uses the target", req: makeGETRequest("/basicui/app", "test"), expected: config.DefaultUpstream},
+		{name: "user is routed to their upstream", req: makeGETRequest("/basicui/app", "caretaker"), expected: "holiday"},
+		{name: "route wins over the upstream of the user", req: makeGETRequest("/habpanel/index.html", "caretaker"), expected: "panels"},
+		{name: "longest route wins", req: makeGETRequest("/habpanel/static/app.js", "caretaker"), expected: config.DefaultUpstream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, upstreamName(tt.req, conf))
+		})
+	}
+}
+
+func TestProxyRoutesUsersToTheirUpstream(t *testing.T) {
+	holiday := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("holiday" + r.URL.Path))
+	}))
+	defer holiday.Close()
+
+	_, mux, closeHome := testRouter(t, &config.Main{
+		Upstreams: map[string]*config.Upstream{
+			"holiday": {Target: holiday.URL + "/openhab"},
+		},
+		Users: map[string]*config.User{
+			"caretaker": {Entrypoint: "/start/index", Sitemaps: config.Sitemap{Default: "demo", Allowed: []string{"demo"}}, Upstream: "holiday"},
+			"test":      {Entrypoint: "/start/index", Sitemaps: config.Sitemap{Default: "demo", Allowed: []string{"demo"}}},
+		},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("home"))
+	})
+	defer closeHome()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/paperui/index.html", "caretaker"))
+	assert.Equal(t, "holiday/openhab/paperui/index.html", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/paperui/index.html", "test"))
+	assert.Equal(t, "home", rr.Body.String())
+}
+
+func TestProxyAppliesRulesWithPathPrefixedTarget(t *testing.T) {
+	remoteServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/openhab/rest/items" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`[{"name": "Alarm"}, {"name": "Light"}]`))
+			return
+		}
+		_, _ = w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer remoteServer.Close()
+
+	denied := false
+	router, err := NewRouter(&Options{Target: remoteServer.URL + "/openhab"}, validConfig(t, &config.Main{
+		Users: map[string]*config.User{
+			"demo": {
+				Entrypoint: "/start/index",
+				Sitemaps:   config.Sitemap{Default: "demo", Allowed: []string{"demo"}},
+				Paths:      map[string]*config.Path{"/paperui": {Allowed: false}},
+				Items:      map[string]*config.Item{"Alarm": {Allowed: &denied}},
+			},
+		},
+	}))
+	assert.NoError(t, err)
+	mux := router.MakeMux(router.MakeProxy())
+
+	tests := []struct {
+		uri      string
+		expected string
+	}{
+		{uri: "/", expected: "/openhab/start/index"},
+		{uri: "/paperui/index.html", expected: "/openhab/start/index"},
+		{uri: "/basicui/app?sitemap=alarm", expected: "/openhab/basicui/app?sitemap=demo"},
+		{uri: "/rest/sitemaps/alarm/alarm", expected: "/openhab/rest/sitemaps/demo/demo"},
+		{uri: "/rest/items", expected: `[{"name":"Light"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, makeGETRequest(tt.uri, "demo"))
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, tt.expected, strings.Join(strings.Fields(rr.Body.String()), ""))
+		})
+	}
+}
+
+func TestReadinessHandlerPerUpstream(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	healthyPool, _ := NewPool(config.DefaultUpstream, []string{healthy.URL})
+	brokenPool, _ := NewPool("holiday", []string{broken.URL})
+	router := &Router{
+		config: &config.Main{},
+		Upstreams: map[string]*Pool{
+			config.DefaultUpstream: healthyPool,
+			"holiday":              brokenPool,
+		},
+	}
+	mux := router.MakeMux(nil)
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{path: "/readiness", expected: http.StatusOK},
+		{path: "/readiness/default", expected: http.StatusOK},
+		{path: "/readiness/holiday", expected: http.StatusServiceUnavailable},
+		{path: "/readiness/unknown", expected: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+			assert.Equal(t, tt.expected, rr.Code)
+		})
+	}
+}
+
+func TestPoolFailsOverAndSticks(t *testing.T) {
+	defer setClock(tuesdayMorning)()
+
+	pool, err := NewPool("default", []string{"http://primary:8080", "http://standby:8080"})
+	assert.NoError(t, err)
+	primary, standby := pool.Backends[0], pool.Backends[1]
+
+	assert.Equal(t, primary, pool.Select("test"))
+
+	primary.setHealthy(false)
+	assert.Equal(t, standby, pool.Select("test"))
+
+	// the primary recovered, but the session stays on the standby
+	primary.setHealthy(true)
+	assert.Equal(t, standby, pool.Select("test"))
+	assert.Equal(t, primary, pool.Select("other"))
+
+	standby.eject(time.Minute)
+	assert.Equal(t, primary, pool.Select("test"))
+
+	// unknown users are not pinned
+	assert.Equal(t, primary, pool.Select(""))
+	assert.NotContains(t, pool.sticky, "")
+}
+
+func TestProxyPinsOnlyConfiguredUsers(t *testing.T) {
+	router, mux, closeRemote := testRouter(t, &config.Main{
+		Passthrough: true,
+		Users:       map[string]*config.User{"test": {Entrypoint: "/start/index", Sitemaps: config.Sitemap{Default: "demo", Allowed: []string{"demo"}}}},
+	}, func(w http.ResponseWriter, r *http.Request) {})
+	defer closeRemote()
+
+	for _, user := range []string{"test", "random-1", "random-2"} {
+		mux.ServeHTTP(httptest.NewRecorder(), makeGETRequest("/rest/items", user))
+	}
+	assert.Len(t, router.Upstreams[config.DefaultUpstream].sticky, 1)
+	assert.Contains(t, router.Upstreams[config.DefaultUpstream].sticky, "test")
+}
+
+func TestPoolFallsBackToFirstBackend(t *testing.T) {
+	pool, _ := NewPool("default", []string{"http://primary:8080", "http://standby:8080"})
+	for _, backend := range pool.Backends {
+		backend.setHealthy(false)
+	}
+
+	assert.Equal(t, pool.Backends[0], pool.Current())
+}
+
+func TestHealthChecksMarkBackends(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	pool, _ := NewPool(config.DefaultUpstream, []string{broken.URL, healthy.URL})
+	router := &Router{config: &config.Main{}, Upstreams: map[string]*Pool{config.DefaultUpstream: pool}}
+	router.checkBackends(http.DefaultClient)
+
+	assert.False(t, pool.Backends[0].Available())
+	assert.True(t, pool.Backends[1].Available())
+	assert.Equal(t, pool.Backends[1], pool.Current())
+}
+
+func TestUnreachableBackendIsEjected(t *testing.T) {
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("standby"))
+	}))
+	defer standby.Close()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	primary.Close()
+
+	router, err := NewRouter(&Options{Target: primary.URL + "," + standby.URL}, validConfig(t, &config.Main{Passthrough: true}))
+	assert.NoError(t, err)
+	mux := router.MakeMux(router.MakeProxy())
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/items", ""))
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.False(t, router.Upstreams[config.DefaultUpstream].Backends[0].Available())
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, makeGETRequest("/rest/items", ""))
+	assert.Equal(t, "standby", rr.Body.String())
+}
+
+func Test_credentialsDirector(t *testing.T) {
+	conf := &config.Main{
+		UpstreamAuth: &config.Credentials{Token: "default-token"},
+		Users: map[string]*config.User{
+			"kids": {UpstreamAuth: &config.Credentials{Username: "kids", Password: "secret"}},
+			"test": {},
+		},
+	}
+	tests := []struct {
+		name          string
+		user          string
+		passthrough   bool
+		authorization string
+	}{
+		{name: "user credentials replace the client credentials", user: "kids", authorization: "Basic a2lkczpzZWNyZXQ="},
+		{name: "default credentials apply without user credentials", user: "test", authorization: "Bearer default-token"},
+		{name: "default credentials apply in passthrough mode", user: "kids", passthrough: true, authorization: "Bearer default-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf.Passthrough = tt.passthrough
+			req := makeGETRequest("/rest/items", tt.user)
+			req.SetBasicAuth("client", "password")
+			credentialsDirector(req, conf)
+			assert.Equal(t, tt.authorization, req.Header.Get("Authorization"))
+		})
+	}
+}
+
+func TestClientCredentialsAreRemovedWithoutUpstreamCredentials(t *testing.T) {
+	req := makeGETRequest("/rest/items", "test")
+	req.SetBasicAuth("client", "password")
+	credentialsDirector(req, &config.Main{Users: map[string]*config.User{"test": {}}})
+
+	assert.Empty(t, req.Header.Get("Authorization"))
+}
+
+func TestClientCredentialsAreKeptInPassthroughMode(t *testing.T) {
+	req := makeGETRequest("/rest/items", "test")
+	req.SetBasicAuth("client", "password")
+	credentialsDirector(req, &config.Main{Passthrough: true})
+
+	username, _, _ := req.BasicAuth()
+	assert.Equal(t, "client", username)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/rs/zerolog/log"
+)
+
+// maxWebSocketMessageSize limits how much of a message is buffered for inspection
+const maxWebSocketMessageSize = 1024 * 1024
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpClose        = 0x8
+)
+
+// isWebSocketUpgrade checks whether the client asks to switch to the WebSocket protocol
+func isWebSocketUpgrade(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade")
+}
+
+// ConnectionLimiter counts the open WebSocket connections
+type ConnectionLimiter struct {
+	mu    sync.Mutex
+	total int
+	users map[string]int
+}
+
+// NewConnectionLimiter creates a limiter without open connections
+func NewConnectionLimiter() *ConnectionLimiter {
+	return &ConnectionLimiter{users: map[string]int{}}
+}
+
+// Acquire registers a connection of the user, if the limits allow it;
+// the returned func has to be called once the connection is closed
+func (c *ConnectionLimiter) Acquire(user string, limits config.WebSockets) (func(), bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if limits.MaxConnections > 0 && c.total >= limits.MaxConnections {
+		return nil, false
+	}
+	if limits.MaxConnectionsPerUser > 0 && c.users[user] >= limits.MaxConnectionsPerUser {
+		return nil, false
+	}
+	c.total++
+	c.users[user]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.total--
+			if c.users[user]--; c.users[user] <= 0 {
+				delete(c.users, user)
+			}
+		})
+	}, true
+}
+
+// wsFrame is a single WebSocket frame; the payload is unmasked
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// parseFrame reads the frame at the start of b; it returns no frame, if b is incomplete
+func parseFrame(b []byte) (*wsFrame, int, error) {
+	if len(b) < 2 {
+		return nil, 0, nil
+	}
+	frame := &wsFrame{fin: b[0]&0x80 != 0, opcode: b[0] & 0x0f}
+	masked := b[1]&0x80 != 0
+	length := uint64(b[1] & 0x7f)
+	offset := 2
+
+	switch length {
+	case 126:
+		if len(b) < offset+2 {
+			return nil, 0, nil
+		}
+		length = uint64(binary.BigEndian.Uint16(b[offset:]))
+		offset += 2
+	case 127:
+		if len(b) < offset+8 {
+			return nil, 0, nil
+		}
+		length = binary.BigEndian.Uint64(b[offset:])
+		offset += 8
+	}
+	if length > maxWebSocketMessageSize {
+		return nil, 0, fmt.Errorf("websocket frame of %d bytes exceeds the limit", length)
+	}
+
+	var mask []byte
+	if masked {
+		if len(b) < offset+4 {
+			return nil, 0, nil
+		}
+		mask = b[offset : offset+4]
+		offset += 4
+	}
+
+	end := offset + int(length)
+	if len(b) < end {
+		return nil, 0, nil
+	}
+	frame.payload = make([]byte, length)
+	copy(frame.payload, b[offset:end])
+	for i := range mask {
+		for j := i; j < len(frame.payload); j += 4 {
+			frame.payload[j] ^= mask[i]
+		}
+	}
+	return frame, end, nil
+}
+
+// wsStream reassembles the messages of one direction of a connection and
+// only forwards the ones the filter allows; control frames are always forwarded
+type wsStream struct {
+	allow   func(opcode byte, payload []byte) bool
+	buf     []byte
+	opcode  byte
+	raw     []byte
+	payload []byte
+}
+
+func (s *wsStream) process(data []byte) ([]byte, error) {
+	s.buf = append(s.buf, data...)
+
+	var out []byte
+	offset := 0
+	for {
+		frame, n, err := parseFrame(s.buf[offset:])
+		if err != nil {
+			return nil, err
+		}
+		if frame == nil {
+			break
+		}
+		raw := s.buf[offset : offset+n]
+		offset += n
+
+		if frame.opcode >= wsOpClose {
+			out = append(out, raw...)
+			continue
+		}
+		if frame.opcode != wsOpContinuation {
+			s.opcode = frame.opcode
+			s.raw = s.raw[:0]
+			s.payload = s.payload[:0]
+		}
+		s.raw = append(s.raw, raw...)
+		s.payload = append(s.payload, frame.payload...)
+		if len(s.payload) > maxWebSocketMessageSize {
+			return nil, fmt.Errorf("websocket message exceeds the limit of %d bytes", maxWebSocketMessageSize)
+		}
+		if !frame.fin {
+			continue
+		}
+		if s.allow == nil || s.allow(s.opcode, s.payload) {
+			out = append(out, s.raw...)
+		}
+		s.raw = s.raw[:0]
+		s.payload = s.payload[:0]
+	}
+
+	s.buf = append(s.buf[:0], s.buf[offset:]...)
+	return out, nil
+}
+
+// filteringConn wraps the connection to openHAB after the protocol switch.
+//
+// Reads carry the frames sent by openHAB, writes the frames of the client.
+type filteringConn struct {
+	conn    io.ReadWriteCloser
+	down    wsStream
+	up      wsStream
+	chunk   []byte
+	pending bytes.Buffer
+
+	mu    sync.Mutex
+	idle  time.Duration
+	timer *time.Timer
+}
+
+func newFilteringConn(conn io.ReadWriteCloser, down, up func(byte, []byte) bool, idle time.Duration) *filteringConn {
+	c := &filteringConn{
+		conn:  conn,
+		down:  wsStream{allow: down},
+		up:    wsStream{allow: up},
+		chunk: make([]byte, 32*1024),
+		idle:  idle,
+	}
+	if idle > 0 {
+		c.timer = time.AfterFunc(idle, func() {
+			log.Debug().Dur("idle_timeout", idle).Msg("closing idle websocket connection")
+			_ = c.conn.Close()
+		})
+	}
+	return c
+}
+
+func (c *filteringConn) touch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Reset(c.idle)
+	}
+}
+
+func (c *filteringConn) Read(p []byte) (int, error) {
+	for c.pending.Len() == 0 {
+		n, err := c.conn.Read(c.chunk)
+		if n > 0 {
+			c.touch()
+			out, perr := c.down.process(c.chunk[:n])
+			if perr != nil {
+				_ = c.Close()
+				return 0, perr
+			}
+			c.pending.Write(out)
+		}
+		if err != nil {
+			if c.pending.Len() > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	return c.pending.Read(p)
+}
+
+func (c *filteringConn) Write(p []byte) (int, error) {
+	c.touch()
+	out, err := c.up.process(p)
+	if err != nil {
+		_ = c.Close()
+		return 0, err
+	}
+	if len(out) > 0 {
+		if _, err := c.conn.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (c *filteringConn) Close() error {
+	c.mu.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.mu.Unlock()
+	return c.conn.Close()
+}
+
+// wsEvent is an openHAB event as sent over `/ws`
+type wsEvent struct {
+	Type    string `json:"type"`
+	Topic   string `json:"topic"`
+	Payload string `json:"payload"`
+}
+
+// topicItems returns the items of topics like `openhab/items/<name>/statechanged`;
+// group events also name the member, e.g. `openhab/items/<group>/<member>/statechanged`
+func topicItems(topic string) []string {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || parts[1] != "items" {
+		return nil
+	}
+	return parts[2 : len(parts)-1]
+}
+
+// webSocketFilters drops events of items the user may not see and commands the user may not send
+func webSocketFilters(name string, user *config.User) (down, up func(byte, []byte) bool) {
+	down = func(opcode byte, payload []byte) bool {
+		var event wsEvent
+		if opcode != wsOpText || json.Unmarshal(payload, &event) != nil {
+			return true
+		}
+		for _, item := range topicItems(event.Topic) {
+			if !user.ItemAllowed(item) {
+				return false
+			}
+		}
+		return true
+	}
+
+	up = func(opcode byte, payload []byte) bool {
+		var event wsEvent
+		if opcode != wsOpText || json.Unmarshal(payload, &event) != nil {
+			return true
+		}
+		if event.Type != "ItemCommandEvent" && event.Type != "ItemStateEvent" {
+			return true
+		}
+
+		// the payload is JSON itself, e.g. `{"type":"OnOff","value":"ON"}`
+		var value struct {
+			Value string `json:"value"`
+		}
+		command := event.Payload
+		if json.Unmarshal([]byte(event.Payload), &value) == nil {
+			command = value.Value
+		}
+		for _, item := range topicItems(event.Topic) {
+			if !commandAllowed(user, item, command) {
+				log.Debug().Str("user", name).Str("item", item).Str("command", command).Msg("websocket command denied")
+				return false
+			}
+		}
+		return true
+	}
+
+	return down, up
+}
+
+// filterWebSocket wraps upgraded connections to filter their messages and close them when idle
+func (r *Router) filterWebSocket(resp *http.Response) error {
+	conf := r.CurrentConfig()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil
+	}
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return nil
+	}
+
+	var down, up func(byte, []byte) bool
+	name := requestUser(resp.Request)
+	if user, ok := conf.Users[name]; ok && !conf.Passthrough {
+		down, up = webSocketFilters(name, user)
+	}
+	if down == nil && conf.WebSockets.IdleTimeout == 0 {
+		return nil
+	}
+
+	resp.Body = newFilteringConn(conn, down, up, conf.WebSockets.IdleTimeout)
+	return nil
+}
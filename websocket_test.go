@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hendrikmaus/openhab-auth-router/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeFrame(fin bool, opcode byte, payload string, masked bool) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+
+	var second byte
+	if masked {
+		second = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, second|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, second|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, second|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+
+	data := []byte(payload)
+	if masked {
+		mask := []byte{1, 2, 3, 4}
+		frame = append(frame, mask...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	return append(frame, data...)
+}
+
+func readFrame(t *testing.T, reader *bufio.Reader) *wsFrame {
+	var buf []byte
+	for {
+		frame, _, err := parseFrame(buf)
+		assert.NoError(t, err)
+		if frame != nil {
+			return frame
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil
+		}
+		buf = append(buf, b)
+	}
+}
+
+func Test_parseFrame(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	tests := []struct {
+		name    string
+		input   []byte
+		payload string
+		n       int
+	}{
+		{name: "short", input: encodeFrame(true, wsOpText, "hello", false), payload: "hello", n: 7},
+		{name: "masked", input: encodeFrame(true, wsOpText, "hello", true), payload: "hello", n: 11},
+		{name: "extended length", input: encodeFrame(true, wsOpText, long, false), payload: long, n: 304},
+		{name: "incomplete", input: encodeFrame(true, wsOpText, "hello", false)[:5]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, n, err := parseFrame(tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.n, n)
+			if tt.payload == "" {
+				assert.Nil(t, frame)
+				return
+			}
+			assert.Equal(t, tt.payload, string(frame.payload))
+		})
+	}
+}
+
+func TestFragmentedMessagesAreFilteredAsWhole(t *testing.T) {
+	stream := &wsStream{allow: func(opcode byte, payload []byte) bool {
+		return string(payload) != "denied"
+	}}
+
+	var input []byte
+	input = append(input, encodeFrame(false, wsOpText, "den", false)...)
+	input = append(input, encodeFrame(true, 0x9, "ping", false)...)
+	input = append(input, encodeFrame(true, wsOpContinuation, "ied", false)...)
+	input = append(input, encodeFrame(true, wsOpText, "allowed", false)...)
+
+	// feed the frames byte by byte, as they may arrive in any chunks
+	var out []byte
+	for _, b := range input {
+		chunk, err := stream.process([]byte{b})
+		assert.NoError(t, err)
+		out = append(out, chunk...)
+	}
+
+	expected := append(encodeFrame(true, 0x9, "ping", false), encodeFrame(true, wsOpText, "allowed", false)...)
+	assert.Equal(t, expected, out)
+}
+
+func TestConnectionLimiter(t *testing.T) {
+	limiter := NewConnectionLimiter()
+	limits := config.WebSockets{MaxConnections: 2, MaxConnectionsPerUser: 1}
+
+	release, ok := limiter.Acquire("alice", limits)
+	assert.True(t, ok)
+	_, ok = limiter.Acquire("alice", limits)
+	assert.False(t, ok)
+	_, ok = limiter.Acquire("bob", limits)
+	assert.True(t, ok)
+	_, ok = limiter.Acquire("carol", limits)
+	assert.False(t, ok)
+
+	release()
+	release()
+	_, ok = limiter.Acquire("carol", limits)
+	assert.True(t, ok)
+}
+
+func webSocketRouter(t *testing.T, received chan<- string) (*httptest.Server, func()) {
+	denied := false
+	_, mux, closeRemote := testRouter(t, &config.Main{
+		OpenHABVersion: "4",
+		WebSockets:     config.WebSockets{IdleTimeout: 200 * time.Millisecond, MaxConnectionsPerUser: 1},
+		Users: map[string]*config.User{
+			"guest": {
+				Entrypoint: "/",
+				Sitemaps:   config.Sitemap{Default: "demo", Allowed: []string{"demo"}},
+				Items: map[string]*config.Item{
+					"Alarm":      {Allowed: &denied},
+					"Light_Kids": {Commands: &config.Command{Allowed: []string{"ON", "OFF"}}},
+				},
+			},
+		},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_, _ = buf.Write(encodeFrame(true, wsOpText, `{"type":"ItemStateChangedEvent","topic":"openhab/items/Alarm/statechanged","payload":"{}"}`, false))
+		_, _ = buf.Write(encodeFrame(true, wsOpText, `{"type":"ItemStateChangedEvent","topic":"openhab/items/Light_Kids/statechanged","payload":"{}"}`, false))
+		_ = buf.Flush()
+
+		reader := bufio.NewReader(conn)
+		for {
+			var raw []byte
+			var frame *wsFrame
+			for frame == nil {
+				b, err := reader.ReadByte()
+				if err != nil {
+					return
+				}
+				raw = append(raw, b)
+				frame, _, _ = parseFrame(raw)
+			}
+			received <- string(frame.payload)
+		}
+	})
+	server := httptest.NewServer(mux)
+	return server, func() {
+		server.Close()
+		closeRemote()
+	}
+}
+
+func dialWebSocket(t *testing.T, server *httptest.Server, user string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	assert.NoError(t, err)
+	_, err = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: openhab\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Extensions: permessage-deflate\r\n"+
+		"X-Forwarded-Username: "+user+"\r\n\r\n")
+	assert.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	assert.NoError(t, err)
+	return conn, reader, resp
+}
+
+func TestWebSocketMessagesAreFiltered(t *testing.T) {
+	received := make(chan string, 10)
+	server, closeAll := webSocketRouter(t, received)
+	defer closeAll()
+
+	conn, reader, resp := dialWebSocket(t, server, "guest")
+	defer conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	// events of the hidden item are dropped
+	frame := readFrame(t, reader)
+	assert.Contains(t, string(frame.payload), "Light_Kids")
+
+	// a second connection exceeds the limit
+	secondConn, _, second := dialWebSocket(t, server, "guest")
+	defer secondConn.Close()
+	assert.Equal(t, http.StatusTooManyRequests, second.StatusCode)
+
+	_, _ = conn.Write(encodeFrame(true, wsOpText, `{"type":"ItemCommandEvent","topic":"openhab/items/Light_Kids/command","payload":"{\"type\":\"OnOff\",\"value\":\"DIM\"}"}`, true))
+	_, _ = conn.Write(encodeFrame(true, wsOpText, `{"type":"ItemCommandEvent","topic":"openhab/items/Light_Kids/command","payload":"{\"type\":\"OnOff\",\"value\":\"ON\"}"}`, true))
+	select {
+	case message := <-received:
+		assert.Contains(t, message, `\"ON\"`)
+	case <-time.After(time.Second):
+		t.Fatal("command was not forwarded")
+	}
+
+	// the connection is closed once it is idle
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err := reader.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}